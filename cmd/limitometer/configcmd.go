@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hetalsonavane/azure-request-limitometer/internal/config"
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/common"
+	"gopkg.in/yaml.v2"
+)
+
+// runConfigCommand runs the config subcommands, which inspect the configuration without polling Azure API
+func runConfigCommand(args []string) {
+	if len(args) != 1 || (args[0] != "validate" && args[0] != "print") {
+		fmt.Fprintf(os.Stderr, "usage: %s config [validate|print] [flags]\n", cliName)
+		os.Exit(2)
+	}
+
+	if err := config.ParseEnvironment(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse environment: %s\n", err)
+		os.Exit(1)
+	}
+	settings, err := loadSettings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load configuration: %s\n", err)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "validate":
+		problems := settings.Validate()
+		if _, err := setupProbes(common.AzureClient{}, settings); err != nil {
+			problems = append(problems, fmt.Errorf("probes: %v", err))
+		}
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stderr, problem)
+		}
+		if len(problems) > 0 {
+			fmt.Fprintf(os.Stderr, "configuration is invalid: %d problem(s) found\n", len(problems))
+			os.Exit(1)
+		}
+		fmt.Println("configuration is valid")
+	case "print":
+		out, err := yaml.Marshal(settings.Redacted())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to print configuration: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(string(out))
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/emulator"
+	flag "github.com/spf13/pflag"
+)
+
+// runEmulateCommand serves a local emulator of Azure Resource Manager until interrupted
+func runEmulateCommand(args []string) {
+	flags := flag.NewFlagSet(cliName+" emulate", flag.ExitOnError)
+	listenAddress := flags.String("listen-address", ":8081", "Address the emulator listens on")
+	configFile := flags.String("config", "", "YAML file describing the emulated resource group and its token buckets, a VM and a VM Scale Set if empty")
+	flags.Parse(args)
+
+	config, err := emulator.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("failed to load emulator configuration: %s\n", err)
+	}
+
+	log.Printf("Emulating resource group %s of subscription %s on %s", config.ResourceGroup, config.SubscriptionID, *listenAddress)
+	endpoint := emulatorURL(*listenAddress)
+	log.Printf("Point the limitometer at it with AZURE_RESOURCE_MANAGER_ENDPOINT=%s AZURE_ANONYMOUS=true AZURE_INSTANCE_METADATA_ENDPOINT=%s/metadata/instance", endpoint, endpoint)
+	if err := http.ListenAndServe(*listenAddress, emulator.New(config)); err != nil {
+		log.Fatalf("failed to serve emulator: %s\n", err)
+	}
+	os.Exit(0)
+}
+
+// emulatorURL returns the URL the emulator is reached at from the same host, given the address it
+// listens on, such as ":8081" or "127.0.0.1:8081"
+func emulatorURL(listenAddress string) string {
+	host, port, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		return "http://" + listenAddress
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
@@ -10,13 +10,14 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/hetalsonavane/azure-request-limitometer/internal/config"
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/alerts"
 	"github.com/hetalsonavane/azure-request-limitometer/pkg/common"
 	"github.com/hetalsonavane/azure-request-limitometer/pkg/outputs"
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/probes"
 	flag "github.com/spf13/pflag"
 )
 
-var azureClient = common.Client
-
 const (
 	cliName        = "limitometer"
 	cliDescription = "Collects the number of remaining requests in Azure Resource Manager"
@@ -24,16 +25,30 @@ const (
 )
 
 var (
-	nodename     = flag.String("node", "", "Valid node in the resource group to create compute queries. Environment Variable: NODE_NAME")
-	target       = flag.String("output", "pushgateway", "Target output for the limitometer, supported values are: [influxdb|pushgateway]")
-	mode         = flag.String("mode", "oneshot", "Operational mode for limitometer, supported values are: [oneshot|service]")
-	pollInterval = flag.Int("poll-interval", 60, "Only for 'service' mode: Poll interval for refreshing metrics in seconds")
+	configFile     = flag.String("config", "", "YAML configuration file, overridden by the environment and the flags. Environment Variable: LIMITOMETER_CONFIG")
+	nodename       = flag.String("node", "", "Valid node in the resource group to create compute queries. Environment Variable: NODE_NAME")
+	scaleSet       = flag.String("vmss", "", "VM Scale Set in the resource group to create compute queries, the node is then an instance of it. Environment Variable: VMSS_NAME")
+	instance       = flag.String("vmss-instance", "", "Instance ID of the VM Scale Set to create compute queries, derived from the node name if empty. Environment Variable: VMSS_INSTANCE_ID")
+	targets        = flag.StringSlice("output", []string{"pushgateway"}, fmt.Sprintf("Target outputs for the limitometer, several can be given separated by commas, supported values are: [%s]. prometheus is only supported in 'service' and 'proxy' mode", strings.Join(outputs.SinkNames(), "|")))
+	mode           = flag.String("mode", "oneshot", "Operational mode for limitometer, supported values are: [oneshot|service|proxy]. proxy relays the requests of other clients and harvests their responses instead of polling")
+	pollInterval   = flag.Int("poll-interval", 60, "Only for 'service' and 'proxy' mode: Poll interval for refreshing metrics in seconds")
+	proxyListen    = flag.String("proxy-listen-address", "localhost:8082", "Only for 'proxy' mode: Address the proxy listens on. Environment Variable: PROXY_LISTEN_ADDRESS")
+	proxyUpstream  = flag.String("proxy-upstream", "", "Only for 'proxy' mode: Endpoint the requests made to the proxy as a reverse proxy are relayed to, defaults to the Azure Resource Manager endpoint of the cloud. Environment Variable: PROXY_UPSTREAM")
+	cloudConfig    = flag.String("cloud-config", "", fmt.Sprintf("Kubernetes Azure cloud provider configuration to read the settings not provided by environment from, defaults to %s if it exists. Environment Variable: AZURE_CLOUD_CONFIG", config.DefaultCloudProviderConfigPath))
+	discover       = flag.Bool("discover", true, "Discover the subscription, resource group, location, node and VM Scale Set that are not provided through the Azure Instance Metadata Service")
+	enabledProbes  = flag.StringSlice("probes", nil, fmt.Sprintf("Probes to run against Azure API, defaults to: [%s] or [%s] with --vmss", strings.Join(probes.Defaults(probes.Target{}), "|"), strings.Join(probes.Defaults(probes.Target{ScaleSet: "vmss"}), "|")))
+	disabledProbes = flag.StringSlice("disable-probes", nil, "Probes to skip from the enabled probes")
+	cluster        = flag.String("cluster", "", "Name of the cluster the limitometer runs for, used as a metric label. Environment Variable: CLUSTER_NAME")
+	metricLabels   = flag.StringSlice("metric-labels", nil, fmt.Sprintf("Optional labels added to the pushgateway output and the tagged InfluxDB schema, supported values are: [%s]", strings.Join(outputs.LabelNames, "|")))
+	notifiers      = flag.StringSlice("notifiers", []string{"log"}, fmt.Sprintf("Notifiers the events of the alert rules of the configuration file are sent to, supported values are: [%s]", strings.Join(alerts.NotifierNames(), "|")))
+	resourceProbes = flag.StringArray("resource-probe", nil, "Additional probe doing a GET on an ARM resource, format: name=path@api-version[:low|high|write]. The path may contain {subscriptionId} and {resourceGroupName}")
 )
 
 func printUsage() {
 	if flag.Args()[0] == "help" {
 		fmt.Printf("%s\n\n", cliName)
 		fmt.Println(cliDescription)
+		fmt.Printf("\nSubcommands:\n  config validate\tValidate the configuration without polling Azure API\n  config print\t\tPrint the merged configuration with secrets redacted\n  emulate\t\tServe a local emulator of Azure Resource Manager, see emulate --help\n\nFlags:\n")
 		flag.PrintDefaults()
 		os.Exit(2)
 	}
@@ -46,44 +61,178 @@ func printHelp() {
 	}
 }
 
-func getValuesAndWriteToOutput(nodename string) {
-	log.Printf("Querying Azure API for remaining requests")
-	requestsRemaining := getRequestsRemaining(nodename)
+// getMetadata returns the values of the optional labels selected through -metric-labels
+func getMetadata(s config.Settings) (metadata outputs.Metadata) {
+	for _, label := range s.Outputs.MetricLabels {
+		switch label {
+		case "subscription":
+			metadata.Subscription = s.Target.SubscriptionID
+		case "resource_group":
+			metadata.ResourceGroup = s.Target.ResourceGroup
+		case "cluster":
+			metadata.Cluster = s.Target.Cluster
+		case "node":
+			metadata.Node = s.Target.Node
+		}
+	}
+	return
+}
 
-	log.Printf("Writing to database: %s", *target)
-	if strings.ToLower(*target) == "influxdb" {
-		outputs.WriteOutputInflux(requestsRemaining, "requestRemaining")
-	} else if strings.ToLower(*target) == "pushgateway" {
-		outputs.WriteOutputPushGateway(requestsRemaining)
-	} else {
-		glog.Exit("Did not provide a output through -output flag. Exiting.")
+// getTargetMetadata returns the values of all the optional labels, for the outputs and notifiers
+// describing where the polls are made regardless of -metric-labels
+func getTargetMetadata(s config.Settings) outputs.Metadata {
+	return outputs.Metadata{
+		Subscription:  s.Target.SubscriptionID,
+		ResourceGroup: s.Target.ResourceGroup,
+		Cluster:       s.Target.Cluster,
+		Node:          s.Target.Node,
 	}
 }
 
+// targetOf returns the resources the probes are made against
+func targetOf(s config.Settings) probes.Target {
+	return probes.Target{
+		Node:     s.Target.Node,
+		ScaleSet: s.Target.ScaleSet,
+		Instance: s.Target.Instance,
+	}
+}
+
+// setupProbes registers the resource probes of the settings and creates the enabled probes
+func setupProbes(client common.AzureClient, s config.Settings) ([]probes.Probe, error) {
+	for _, definition := range s.Probes.Resources {
+		if err := probes.RegisterResourceProbe(definition); err != nil {
+			return nil, err
+		}
+	}
+	return probes.New(client, targetOf(s), s.Probes.Enabled, s.Probes.Disabled)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func getValuesAndWriteToOutput(activeProbes []probes.Probe, throttling *throttling, history *outputs.History, engine *alerts.Engine, sinks []outputs.Sink, metadata outputs.Metadata, target outputs.Metadata) {
+	log.Printf("Querying Azure API for remaining requests")
+	requestsRemaining, stale, statuses, throttles := getRequestsRemaining(activeProbes, throttling)
+
+	poll := outputs.Poll{
+		Values:    requestsRemaining,
+		Stale:     stale,
+		Statuses:  statuses,
+		Throttles: throttles,
+		Metadata:  metadata,
+		Target:    target,
+		Time:      time.Now(),
+	}
+	history.Update(&poll)
+	engine.Evaluate(&poll)
+	outputs.WriteAll(sinks, poll)
+}
+
 func main() {
+	// the emulator has flags of its own
+	if len(os.Args) > 1 && os.Args[1] == "emulate" {
+		runEmulateCommand(os.Args[2:])
+	}
 	flag.Parse()
 
+	if len(flag.Args()) > 0 && flag.Args()[0] == "config" {
+		runConfigCommand(flag.Args()[1:])
+	}
 	if len(flag.Args()) > 0 {
 		printHelp()
 		printUsage()
 	}
 
-	//if err := config.ParseEnvironment(); err != nil {
-	//	log.Fatalf("failed to parse environment: %s\n", err)
-	//}
+	if err := config.ParseEnvironment(); err != nil {
+		log.Fatalf("failed to parse environment: %s\n", err)
+	}
+	settings, err := loadSettings()
+	if err != nil {
+		log.Fatalf("failed to load configuration: %s\n", err)
+	}
+	settings.Apply()
+
+	for _, label := range settings.Outputs.MetricLabels {
+		if !contains(outputs.LabelNames, label) {
+			log.Fatalf("unknown metric label %q, supported values are: [%s]", label, strings.Join(outputs.LabelNames, "|"))
+		}
+	}
+
+	mode := strings.ToLower(settings.Schedule.Mode)
+	if len(settings.Outputs.Targets) == 0 {
+		glog.Exit("Did not provide a output through -output flag. Exiting.")
+	}
+	for _, target := range settings.Outputs.Targets {
+		if strings.ToLower(target) == "prometheus" && mode != "service" && mode != "proxy" {
+			glog.Exit("The prometheus output is only supported in service and proxy mode. Exiting.")
+		}
+	}
+
+	sinks, err := outputs.NewSinks(settings.Outputs.Targets)
+	if err != nil {
+		log.Fatalf("failed to set up outputs: %s\n", err)
+	}
+	metadata := getMetadata(settings)
+	target := getTargetMetadata(settings)
 
-	env, exists := os.LookupEnv("NODE_NAME")
-	if exists {
-		*nodename = env
+	if problems := alerts.ValidateRules(settings.Alerts.Rules); len(problems) > 0 {
+		log.Fatalf("invalid alert %s\n", problems[0])
 	}
+	alertNotifiers, err := alerts.NewNotifiers(settings.Alerts.Notifiers)
+	if err != nil {
+		log.Fatalf("failed to set up notifiers: %s\n", err)
+	}
+	engine := alerts.NewEngine(settings.Alerts.Rules, alertNotifiers, target)
+
+	if mode == "proxy" {
+		upstream := settings.Proxy.Upstream
+		if upstream == "" {
+			upstream = config.ResourceManagerEndpoint()
+		}
+		log.Printf("Running in proxy mode, will write the remaining requests of the relayed responses every %d seconds", settings.Schedule.PollInterval)
+		interval := time.Duration(settings.Schedule.PollInterval) * time.Second
+		if err := runProxy(settings.Proxy.ListenAddress, upstream, interval, engine, sinks, metadata, target); err != nil {
+			log.Fatalf("failed to run proxy: %s\n", err)
+		}
+		os.Exit(0)
+	}
+
+	authorizer, err := common.NewAuthorizer()
+	if err != nil {
+		log.Fatalf("failed to create authorizer: %s\n", err)
+	}
+	azureClient := common.NewClient(common.DefaultClientConfig(), authorizer)
 
-	log.Printf("Starting limitometer with %s as target VM", *nodename)
-	if strings.ToLower(*mode) == "oneshot" {
+	activeProbes, err := setupProbes(azureClient, settings)
+	if err != nil {
+		log.Fatalf("failed to set up probes: %s\n", err)
+	}
+	probeTarget := targetOf(settings)
+	throttling := newThrottling()
+	history := outputs.NewHistory()
+
+	if probeTarget.UsesScaleSet() {
+		log.Printf("Starting limitometer with instance %s of %s as target VM Scale Set", probeTarget.Instance, probeTarget.ScaleSet)
+	} else {
+		log.Printf("Starting limitometer with %s as target VM", probeTarget.Node)
+	}
+	for _, probe := range activeProbes {
+		log.Printf("Enabled probe %s (cost class: %s)", probe.Name(), probe.CostClass())
+	}
+	if mode == "oneshot" {
 		log.Printf("Running in oneshot mode, will get remaining requests once and exit afterwards")
-		getValuesAndWriteToOutput(*nodename)
+		getValuesAndWriteToOutput(activeProbes, throttling, history, engine, sinks, metadata, target)
+		engine.Close()
 		os.Exit(0)
-	} else if strings.ToLower(*mode) == "service" {
-		log.Printf("Running in service mode, will poll Azure API every %d seconds", *pollInterval)
+	} else if mode == "service" {
+		log.Printf("Running in service mode, will poll Azure API every %d seconds", settings.Schedule.PollInterval)
 
 		// set up signal channel to manage SIGINT and SIGTERM
 		done := make(chan os.Signal, 1)
@@ -91,8 +240,8 @@ func main() {
 
 		go func() {
 			for {
-				getValuesAndWriteToOutput(*nodename)
-				time.Sleep(time.Duration(*pollInterval) * time.Second)
+				getValuesAndWriteToOutput(activeProbes, throttling, history, engine, sinks, metadata, target)
+				time.Sleep(time.Duration(settings.Schedule.PollInterval) * time.Second)
 			}
 		}()
 
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/alerts"
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/outputs"
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/ratelimit"
+)
+
+// newProxy returns a handler relaying requests to upstream, as a reverse proxy, or to the URL
+// they are made for, as a forward proxy. The remaining requests are recorded from the relayed
+// responses into the snapshot. So that the proxy cannot be used to reach anything else, such as
+// the Instance Metadata Service, forward requests are only relayed to the host of upstream, and
+// CONNECT tunnels are refused, nothing could be recorded from the TLS traffic they carry anyway.
+func newProxy(upstream *url.URL, snapshot *ratelimit.Snapshot) http.Handler {
+	transport := ratelimit.NewRoundTripper(http.DefaultTransport, snapshot)
+
+	reverse := httputil.NewSingleHostReverseProxy(upstream)
+	director := reverse.Director
+	reverse.Director = func(r *http.Request) {
+		director(r)
+		r.Host = upstream.Host
+	}
+	reverse.Transport = transport
+
+	forward := &httputil.ReverseProxy{
+		Director:  func(r *http.Request) {},
+		Transport: transport,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodConnect:
+			http.Error(w, "CONNECT is not supported", http.StatusMethodNotAllowed)
+		case r.URL.IsAbs() && !strings.EqualFold(r.URL.Host, upstream.Host):
+			http.Error(w, fmt.Sprintf("only requests to %s are relayed", upstream.Host), http.StatusForbidden)
+		case r.URL.IsAbs():
+			forward.ServeHTTP(w, r)
+		default:
+			reverse.ServeHTTP(w, r)
+		}
+	})
+}
+
+// runProxy relays the ARM traffic of other clients and writes the remaining requests harvested
+// from it to the sinks every interval, without making any request of its own
+func runProxy(listenAddress string, upstream string, interval time.Duration, engine *alerts.Engine, sinks []outputs.Sink, metadata outputs.Metadata, target outputs.Metadata) error {
+	upstreamURL, err := url.Parse(upstream)
+	if err != nil || upstreamURL.Scheme == "" || upstreamURL.Host == "" {
+		return fmt.Errorf("invalid upstream %q", upstream)
+	}
+
+	snapshot := ratelimit.NewSnapshot()
+	history := outputs.NewHistory()
+	go func() {
+		for range time.Tick(interval) {
+			values, updated, ok := snapshot.Flush()
+			if !ok {
+				continue
+			}
+			poll := outputs.Poll{
+				Values:   values,
+				Metadata: metadata,
+				Target:   target,
+				Time:     updated,
+			}
+			history.Update(&poll)
+			engine.Evaluate(&poll)
+			outputs.WriteAll(sinks, poll)
+		}
+	}()
+
+	log.Printf("Relaying requests to %s on %s", upstreamURL, listenAddress)
+	return http.ListenAndServe(listenAddress, newProxy(upstreamURL, snapshot))
+}
@@ -5,106 +5,72 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"regexp"
-	"strconv"
-	"strings"
 	"time"
 
-	"github.com/Azure/go-autorest/autorest"
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/outputs"
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/probes"
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/ratelimit"
 )
 
-// Example Request Headers:
-// 'x-ms-ratelimit-remaining-resource': 'Microsoft.Compute/HighCostGet3Min;133,Microsoft.Compute/HighCostGet30Min;657'
-// 'x-ms-ratelimit-remaining-resource': 'Microsoft.Compute/LowCostGet3Min;3989,Microsoft.Compute/LowCostGet30Min;31790'
-// 'x-ms-ratelimit-remaining-resource': 'Microsoft.Compute/PutVM3Min;740,Microsoft.Compute/PutVM30Min;3695'
-// `X-Ms-Ratelimit-Remaining-Subscription-Reads: [11535]`
-
-var expectedHeaderField = "X-Ms-Ratelimit-Remaining-Resource"
-var expectedHeaderFormat = regexp.MustCompile(`(Microsoft.\w+\/\w+);(\d+)`)
-var expectedSubIDReadsHeaderField = "X-Ms-Ratelimit-Remaining-Subscription-Reads"
-var subIDReadsHeader = "SubIDReads"
-
-func getRequestsRemaining(nodename string) (requestsRemaining map[string]int) {
+// getRequestsRemaining runs every probe and collects the remaining requests from their responses.
+// A failing probe does not stop the poll, its outcome is reported through the returned statuses.
+// A throttled probe is reported through the returned throttles, and the probes affected are
+// skipped until the throttling is over. Meanwhile they report the values of the throttled
+// response, unless a probe that did run returned fresher ones, and those values are returned as
+// stale along with the time of the throttled response.
+func getRequestsRemaining(activeProbes []probes.Probe, throttling *throttling) (requestsRemaining map[string]int, stale map[string]time.Time, statuses []outputs.ProbeStatus, throttles []outputs.Throttle) {
 	requestsRemaining = make(map[string]int)
+	stale = make(map[string]time.Time)
+	held := make(map[string]int)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	Getvm, err := azureClient.GetVM(ctx, nodename)
-	if err != nil {
-		log.Printf("failed to get vm: %s\n", err)
-	}
-
-	Getnic, err := azureClient.GetNicFromVMName(nodename)
-	if err != nil {
-		log.Printf("failed to get nic: %s\n", err)
-	}
-
-	GetAllVM := azureClient.GetAllVM()
-	//putvm := azureClient.PutVM(nodename)
-	GetAllNic := azureClient.GetAllNics()
-	Getlb, err := azureClient.GetAllLoadBalancer()
-	if err != nil {
-		log.Printf("failed to get nic: %s\n", err)
-	}
-	responses := []autorest.Response{
-
-		Getvm.Response,
-		Getnic.Response,
-		Getlb.Response().Response,
-		GetAllVM.Response().Response,
-		GetAllNic.Response().Response,
-		//putvm,
-	}
-	fmt.Println(responses)
+	for _, probe := range activeProbes {
+		if until, values, suspended := throttling.suspendedUntil(probe.Name(), time.Now()); suspended {
+			for k, v := range values.values {
+				held[k] = v
+				stale[k] = values.time
+			}
+			statuses = append(statuses, outputs.ProbeStatus{
+				Probe:      probe.Name(),
+				StatusCode: http.StatusTooManyRequests,
+				Error:      fmt.Sprintf("suspended after being throttled until %s", until.Format(time.RFC3339)),
+			})
+			continue
+		}
 
-	for _, response := range responses {
-		if response.StatusCode != 200 {
-			log.Fatalf("Response did not return a StatusCode of 200. StatusCode: %d", response.StatusCode)
+		result, err := probe.Run(ctx)
+		values := ratelimit.Extract(result.Header)
+
+		status := outputs.ProbeStatus{Probe: probe.Name(), StatusCode: result.StatusCode}
+		if result.StatusCode == http.StatusTooManyRequests {
+			throttles = append(throttles, throttling.throttled(probe.Name(), result.Header, values, time.Now()))
+			status.Error = "Request was throttled by Azure API"
+		} else if err != nil {
+			status.Error = err.Error()
+		} else if result.StatusCode != 200 {
+			status.Error = fmt.Sprintf("Response did not return a StatusCode of 200. StatusCode: %d", result.StatusCode)
 		}
-		for k, v := range extractRequestsRemaining(response.Header) {
-			requestsRemaining[k] = v
+		if status.Error != "" {
+			log.Printf("probe %s failed: %s\n", probe.Name(), status.Error)
 		}
-		for k, v := range extractSubIDRequestsRemaining(response.Header) {
+		statuses = append(statuses, status)
+
+		// ARM returns the remaining requests on error responses as well, e.g. on a 404
+		throttling.observe(probe.Name(), values)
+		for k, v := range values {
 			requestsRemaining[k] = v
 		}
 	}
 
-	return
-}
-
-func extractRequestsRemaining(h http.Header) (requestsRemaining map[string]int) {
-	requestsRemaining = map[string]int{}
-
-	headerSubfields := strings.Split(h.Get(expectedHeaderField), ",")
-
-	for _, field := range headerSubfields {
-
-		matches := expectedHeaderFormat.FindStringSubmatch(field)
-		if !(len(matches) == 3) {
+	for k, v := range held {
+		if _, exists := requestsRemaining[k]; exists {
+			delete(stale, k)
 			continue
 		}
-
-		requestType := matches[1]
-		requestsLeft, err := strconv.Atoi(matches[2])
-		if err != nil {
-			log.Fatal(err)
-		}
-		requestsRemaining[requestType] = requestsLeft
+		requestsRemaining[k] = v
 	}
 
-	return requestsRemaining
-}
-
-func extractSubIDRequestsRemaining(h http.Header) (requestsRemaining map[string]int) {
-	requestsRemaining = map[string]int{}
-	subIDReadsHeaderField := h.Get(expectedSubIDReadsHeaderField)
-	if subIDReadsHeaderField != "" {
-		requestLeft, err := strconv.Atoi(subIDReadsHeaderField)
-		if err != nil {
-			log.Fatal(err)
-		}
-		requestsRemaining[subIDReadsHeader] = requestLeft
-	}
-	return requestsRemaining
+	return
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/hetalsonavane/azure-request-limitometer/internal/config"
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/common"
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/probes"
+	flag "github.com/spf13/pflag"
+)
+
+// applyFlags overrides the settings with the flags given on the command line
+func applyFlags(s *config.Settings) {
+	for name, apply := range map[string]func(){
+		"node":                 func() { s.Target.Node = *nodename },
+		"vmss":                 func() { s.Target.ScaleSet = *scaleSet },
+		"vmss-instance":        func() { s.Target.Instance = *instance },
+		"cluster":              func() { s.Target.Cluster = *cluster },
+		"discover":             func() { s.Target.Discover = *discover },
+		"cloud-config":         func() { s.Auth.CloudConfig = *cloudConfig },
+		"output":               func() { s.Outputs.Targets = *targets },
+		"metric-labels":        func() { s.Outputs.MetricLabels = *metricLabels },
+		"probes":               func() { s.Probes.Enabled = *enabledProbes },
+		"disable-probes":       func() { s.Probes.Disabled = *disabledProbes },
+		"resource-probe":       func() { s.Probes.Resources = append(s.Probes.Resources, *resourceProbes...) },
+		"mode":                 func() { s.Schedule.Mode = *mode },
+		"poll-interval":        func() { s.Schedule.PollInterval = *pollInterval },
+		"proxy-listen-address": func() { s.Proxy.ListenAddress = *proxyListen },
+		"proxy-upstream":       func() { s.Proxy.Upstream = *proxyUpstream },
+		"notifiers":            func() { s.Alerts.Notifiers = *notifiers },
+	} {
+		if flag.CommandLine.Changed(name) {
+			apply()
+		}
+	}
+}
+
+// loadSettings merges every configuration source, discovering what is left empty
+func loadSettings() (s config.Settings, err error) {
+	path, exists := os.LookupEnv("LIMITOMETER_CONFIG")
+	if flag.CommandLine.Changed("config") || !exists {
+		path = *configFile
+	}
+
+	s, err = config.LoadSettings(path)
+	if err != nil {
+		return
+	}
+	applyFlags(&s)
+
+	if s.Auth.CloudConfig != "" {
+		err = s.ApplyCloudProviderConfig(s.Auth.CloudConfig, true)
+	} else {
+		err = s.ApplyCloudProviderConfig(config.DefaultCloudProviderConfigPath, false)
+	}
+	if err != nil {
+		return
+	}
+
+	// the Instance Metadata Service is only queried for what no other source provided, the VM Scale
+	// Set included as it tells a node of a scale set from a standalone VM
+	complete := s.Target.SubscriptionID != "" && s.Target.ResourceGroup != "" && s.Target.Location != "" &&
+		s.Target.Node != "" && s.Target.ScaleSet != ""
+	discovered, err := common.LoadConfig(s.Target.Discover && !complete)
+	if err != nil {
+		return
+	}
+	fill := func(field *string, value string) {
+		if *field == "" {
+			*field = value
+		}
+	}
+	fill(&s.Target.SubscriptionID, discovered.SubscriptionID)
+	fill(&s.Target.ResourceGroup, discovered.ResourceGroup)
+	fill(&s.Target.Location, discovered.Location)
+	fill(&s.Target.Node, discovered.VMName)
+	fill(&s.Target.ScaleSet, discovered.VMScaleSetName)
+	if s.Target.VMType == "vmss" {
+		fill(&s.Target.ScaleSet, s.Target.PrimaryScaleSet)
+	}
+
+	if s.Target.ScaleSet != "" && s.Target.Instance == "" {
+		id, ok := probes.InstanceFromNodeName(s.Target.Node, s.Target.ScaleSet)
+		if !ok {
+			err = fmt.Errorf("could not derive the instance ID of VM Scale Set %s from node %s, provide it through -vmss-instance", s.Target.ScaleSet, s.Target.Node)
+			return
+		}
+		s.Target.Instance = id
+	}
+
+	log.Printf("Using subscription %s and resource group %s", s.Target.SubscriptionID, s.Target.ResourceGroup)
+	return
+}
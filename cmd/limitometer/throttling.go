@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/outputs"
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/ratelimit"
+)
+
+// defaultRetryAfter is how long probes are suspended when a throttled response has no Retry-After
+const defaultRetryAfter = time.Minute
+
+// throttling keeps the probes suspended after a throttled request, along with the values of the
+// throttled response they keep reporting meanwhile, and the buckets every probe was seen drawing on.
+// It is only used by the polling loop.
+type throttling struct {
+	suspended map[string]time.Time
+	held      map[string]heldValues
+	buckets   map[string]map[string]bool
+}
+
+// heldValues are the values a suspended probe reports, along with when they were received
+type heldValues struct {
+	values map[string]int
+	time   time.Time
+}
+
+func newThrottling() *throttling {
+	return &throttling{
+		suspended: map[string]time.Time{},
+		held:      map[string]heldValues{},
+		buckets:   map[string]map[string]bool{},
+	}
+}
+
+// suspendedUntil returns when the probe can run again, if it is suspended, and the values it
+// reports until then
+func (t *throttling) suspendedUntil(probe string, now time.Time) (time.Time, heldValues, bool) {
+	until, exists := t.suspended[probe]
+	if !exists {
+		return time.Time{}, heldValues{}, false
+	}
+	if !now.Before(until) {
+		delete(t.suspended, probe)
+		delete(t.held, probe)
+		return time.Time{}, heldValues{}, false
+	}
+	return until, t.held[probe], true
+}
+
+// observe records the buckets found in a response of the probe
+func (t *throttling) observe(probe string, values map[string]int) {
+	if t.buckets[probe] == nil {
+		t.buckets[probe] = map[string]bool{}
+	}
+	for bucket := range values {
+		t.buckets[probe][bucket] = true
+	}
+}
+
+// throttled records a throttled response of the probe. The probe, and every probe seen drawing on
+// the bucket with the fewest requests remaining, are suspended until the Retry-After elapses. Until
+// then the probe keeps reporting the values of the response, and the others that bucket, as exhausted.
+func (t *throttling) throttled(probe string, header http.Header, values map[string]int, now time.Time) outputs.Throttle {
+	retryAfter, found := ratelimit.RetryAfter(header, now)
+	if !found {
+		retryAfter = defaultRetryAfter
+	}
+	throttle := outputs.Throttle{
+		Probe:      probe,
+		Bucket:     exhaustedBucket(values),
+		RetryAfter: retryAfter,
+	}
+
+	until := now.Add(retryAfter)
+	t.suspend(probe, until)
+	t.hold(probe, values, now)
+	if throttle.Bucket != "" {
+		exhausted := map[string]int{throttle.Bucket: 0}
+		t.hold(probe, exhausted, now)
+		for other, buckets := range t.buckets {
+			if buckets[throttle.Bucket] {
+				t.suspend(other, until)
+				t.hold(other, exhausted, now)
+			}
+		}
+	}
+	log.Printf("probe %s was throttled on bucket %q, suspending the probes drawing on it until %s", probe, throttle.Bucket, until.Format(time.RFC3339))
+	return throttle
+}
+
+func (t *throttling) suspend(probe string, until time.Time) {
+	if until.After(t.suspended[probe]) {
+		t.suspended[probe] = until
+	}
+}
+
+// hold adds the values, received at the given time, to the ones the probe reports while suspended
+func (t *throttling) hold(probe string, values map[string]int, received time.Time) {
+	held := t.held[probe]
+	if held.values == nil {
+		held.values = map[string]int{}
+	}
+	for bucket, remaining := range values {
+		held.values[bucket] = remaining
+	}
+	held.time = received
+	t.held[probe] = held
+}
+
+// exhaustedBucket returns the bucket with the fewest requests remaining, the first by name on a tie
+func exhaustedBucket(values map[string]int) string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bucket := ""
+	for _, name := range names {
+		if bucket == "" || values[name] < values[bucket] {
+			bucket = name
+		}
+	}
+	return bucket
+}
@@ -12,4 +12,5 @@ require (
 	github.com/marstr/randname v0.0.0-20200428202425-99aca53a2176
 	github.com/prometheus/client_golang v1.6.0
 	github.com/spf13/pflag v1.0.5
+	gopkg.in/yaml.v2 v2.2.5
 )
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// DefaultCloudProviderConfigPath is where the Kubernetes Azure cloud provider configuration lives on a node
+const DefaultCloudProviderConfigPath = "/etc/kubernetes/azure.json"
+
+// cloudProviderConfig is the subset of the Kubernetes Azure cloud provider configuration used by the limitometer
+type cloudProviderConfig struct {
+	Cloud                       string `json:"cloud"`
+	TenantID                    string `json:"tenantId"`
+	SubscriptionID              string `json:"subscriptionId"`
+	ResourceGroup               string `json:"resourceGroup"`
+	Location                    string `json:"location"`
+	AADClientID                 string `json:"aadClientId"`
+	AADClientSecret             string `json:"aadClientSecret"`
+	UseManagedIdentityExtension bool   `json:"useManagedIdentityExtension"`
+	UserAssignedIdentityID      string `json:"userAssignedIdentityID"`
+	VMType                      string `json:"vmType"`
+	PrimaryScaleSetName         string `json:"primaryScaleSetName"`
+}
+
+// ApplyCloudProviderConfig loads the Kubernetes Azure cloud provider configuration file, usually
+// /etc/kubernetes/azure.json, to fill the settings that are left empty. It is applied after the
+// configuration file, the environment and the flags, so that every one of them takes precedence.
+// A missing file is only an error when required is true.
+func (s *Settings) ApplyCloudProviderConfig(path string, required bool) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil
+		}
+		return fmt.Errorf("failed to read cloud provider configuration: %v", err)
+	}
+
+	var c cloudProviderConfig
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return fmt.Errorf("failed to parse cloud provider configuration %s: %v", path, err)
+	}
+
+	fill := func(field *string, value string) {
+		if *field == "" {
+			*field = value
+		}
+	}
+	fill(&s.Auth.TenantID, c.TenantID)
+	fill(&s.Target.SubscriptionID, c.SubscriptionID)
+	fill(&s.Target.ResourceGroup, c.ResourceGroup)
+	fill(&s.Target.Location, c.Location)
+	fill(&s.Target.VMType, c.VMType)
+	fill(&s.Target.PrimaryScaleSet, c.PrimaryScaleSetName)
+	if c.Cloud != "" && !s.Auth.cloudSet {
+		s.Auth.Cloud = c.Cloud
+	}
+
+	// the cloud provider uses the client secret unless the managed identity is enabled
+	if s.Auth.ClientID != "" || s.Auth.UseManagedIdentity {
+		return nil
+	}
+	if c.UseManagedIdentityExtension {
+		s.Auth.UseManagedIdentity = true
+		s.Auth.ClientID = c.UserAssignedIdentityID
+	} else if c.AADClientID != "" && c.AADClientID != "msi" {
+		s.Auth.ClientID = c.AADClientID
+		s.Auth.ClientSecret = c.AADClientSecret
+	}
+
+	return nil
+}
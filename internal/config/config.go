@@ -1,4 +1,4 @@
-package common
+package config
 
 import (
 	"bytes"
@@ -13,19 +13,22 @@ var (
 	// each has corresponding public accessors below.
 	// if anything requires a `Set` accessor, that indicates it perhaps
 	// shouldn't be set here, because mutable vars shouldn't be global.
-	clientID               string
-	clientSecret           string
-	tenantID               string
-	subscriptionID         string
-	locationDefault        string
-	authorizationServerURL string
-	cloudName              string = "AzurePublicCloud"
-	useDeviceFlow          bool
-	keepResources          bool
-	groupName              string // deprecated, use baseGroupName instead
-	baseGroupName          string
-	userAgent              string
-	environment            *azure.Environment
+	clientID                string
+	clientSecret            string
+	tenantID                string
+	subscriptionID          string
+	locationDefault         string
+	authorizationServerURL  string
+	cloudName               string = "AzurePublicCloud"
+	useDeviceFlow           bool
+	keepResources           bool
+	groupName               string // deprecated, use baseGroupName instead
+	baseGroupName           string
+	userAgent               string
+	environment             *azure.Environment
+	useManagedIdentity      bool
+	resourceManagerEndpoint string
+	anonymous               bool
 )
 
 // ClientID is the OAuth client ID.
@@ -48,6 +51,27 @@ func SubscriptionID() string {
 	return subscriptionID
 }
 
+// UseManagedIdentity specifies if the managed identity of the VM is used to
+// authenticate, ClientID() then being the user assigned identity if any.
+func UseManagedIdentity() bool {
+	return useManagedIdentity
+}
+
+// ResourceManagerEndpoint is the Azure Resource Manager endpoint requests are
+// made against, the one of the cloud unless overridden, e.g. by a local emulator.
+func ResourceManagerEndpoint() string {
+	if resourceManagerEndpoint != "" {
+		return resourceManagerEndpoint
+	}
+	return Environment().ResourceManagerEndpoint
+}
+
+// Anonymous specifies if requests are sent without credentials, which only
+// a local emulator accepts.
+func Anonymous() bool {
+	return anonymous
+}
+
 // deprecated: use DefaultLocation() instead
 // Location returns the Azure location to be utilized.
 func Location() string {
@@ -1,4 +1,4 @@
-package common
+package config
 
 import (
 	"log"
@@ -20,12 +20,16 @@ func ParseEnvironment() error {
 	var err error
 	useDeviceFlow, err = strconv.ParseBool(os.Getenv("AZURE_USE_DEVICEFLOW"))
 	if err != nil {
-		log.Printf("invalid value specified for AZURE_USE_DEVICEFLOW, disabling\n")
+		if os.Getenv("AZURE_USE_DEVICEFLOW") != "" {
+			log.Printf("invalid value specified for AZURE_USE_DEVICEFLOW, disabling\n")
+		}
 		useDeviceFlow = false
 	}
 	keepResources, err = strconv.ParseBool(os.Getenv("AZURE_SAMPLES_KEEP_RESOURCES"))
 	if err != nil {
-		log.Printf("invalid value specified for AZURE_SAMPLES_KEEP_RESOURCES, discarding\n")
+		if os.Getenv("AZURE_SAMPLES_KEEP_RESOURCES") != "" {
+			log.Printf("invalid value specified for AZURE_SAMPLES_KEEP_RESOURCES, discarding\n")
+		}
 		keepResources = false
 	}
 
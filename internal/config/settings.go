@@ -0,0 +1,365 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/alerts"
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/outputs"
+	"gopkg.in/yaml.v2"
+)
+
+// redacted replaces the secrets of printed settings
+const redacted = "REDACTED"
+
+// Settings is the complete configuration of the limitometer. It is merged from, by increasing
+// precedence: the defaults, the configuration file, the environment and the flags. The Kubernetes
+// cloud provider configuration and the Instance Metadata Service only fill what is left empty.
+type Settings struct {
+	Auth     AuthSettings     `yaml:"auth"`
+	Target   TargetSettings   `yaml:"target"`
+	Probes   ProbeSettings    `yaml:"probes"`
+	Outputs  OutputSettings   `yaml:"outputs"`
+	Schedule ScheduleSettings `yaml:"schedule"`
+	Proxy    ProxySettings    `yaml:"proxy"`
+	Alerts   AlertSettings    `yaml:"alerts"`
+}
+
+// AuthSettings selects the cloud and the credentials used against Azure API. Without client
+// secret nor managed identity, the credentials are taken from the environment by the SDK.
+type AuthSettings struct {
+	Cloud              string `yaml:"cloud"`
+	TenantID           string `yaml:"tenantId"`
+	ClientID           string `yaml:"clientId"`
+	ClientSecret       string `yaml:"clientSecret"`
+	UseManagedIdentity bool   `yaml:"useManagedIdentity"`
+	// CloudConfig is the Kubernetes cloud provider configuration, required when set
+	CloudConfig string `yaml:"cloudConfig"`
+	// ResourceManagerEndpoint overrides the endpoint of the cloud, e.g. to use a local emulator
+	ResourceManagerEndpoint string `yaml:"resourceManagerEndpoint"`
+	// Anonymous sends the requests without credentials, only useful against a local emulator
+	Anonymous bool `yaml:"anonymous"`
+
+	// cloudSet records whether the cloud was given rather than left to its default
+	cloudSet bool
+}
+
+// TargetSettings selects the resources the probes are made against
+type TargetSettings struct {
+	SubscriptionID string `yaml:"subscriptionId"`
+	ResourceGroup  string `yaml:"resourceGroup"`
+	Location       string `yaml:"location"`
+	Node           string `yaml:"node"`
+	ScaleSet       string `yaml:"vmss"`
+	Instance       string `yaml:"vmssInstance"`
+	Cluster        string `yaml:"cluster"`
+	// VMType and PrimaryScaleSet select the scale set when neither given nor discovered
+	VMType          string `yaml:"vmType"`
+	PrimaryScaleSet string `yaml:"primaryScaleSetName"`
+	// Discover fills the empty fields through the Instance Metadata Service
+	Discover bool `yaml:"discover"`
+}
+
+// ProbeSettings selects the probes to run
+type ProbeSettings struct {
+	Enabled  []string `yaml:"enabled"`
+	Disabled []string `yaml:"disabled"`
+	// Resources are additional probe definitions of the form name=path@api-version[:costclass]
+	Resources []string `yaml:"resources"`
+}
+
+// OutputSettings selects the outputs and configures their servers
+type OutputSettings struct {
+	Targets      []string                  `yaml:"targets"`
+	MetricLabels []string                  `yaml:"metricLabels"`
+	InfluxDB     outputs.InfluxDBServer    `yaml:"influxdb"`
+	PushGateway  outputs.PushGatewayServer `yaml:"pushgateway"`
+	Prometheus   outputs.PrometheusServer  `yaml:"prometheus"`
+}
+
+// ScheduleSettings selects how often Azure API is polled
+type ScheduleSettings struct {
+	Mode string `yaml:"mode"`
+	// PollInterval is in seconds and only used in service mode
+	PollInterval int `yaml:"pollInterval"`
+}
+
+// ProxySettings configures the proxy mode, harvesting the remaining requests of the responses
+// relayed to other clients
+type ProxySettings struct {
+	ListenAddress string `yaml:"listenAddress"`
+	// Upstream is where requests are relayed to as a reverse proxy, the ARM endpoint of the cloud if empty
+	Upstream string `yaml:"upstream"`
+}
+
+// AlertSettings configures the alert rules evaluated on every poll and the notifiers their
+// events are sent to
+type AlertSettings struct {
+	Rules     []alerts.Rule        `yaml:"rules"`
+	Notifiers []string             `yaml:"notifiers"`
+	Webhook   alerts.WebhookConfig `yaml:"webhook"`
+}
+
+// DefaultSettings returns the settings used when nothing is configured
+func DefaultSettings() Settings {
+	return Settings{
+		Auth: AuthSettings{
+			Cloud: cloudName,
+		},
+		Target: TargetSettings{
+			Discover: true,
+		},
+		Outputs: OutputSettings{
+			Targets: []string{"pushgateway"},
+		},
+		Schedule: ScheduleSettings{
+			Mode:         "oneshot",
+			PollInterval: 60,
+		},
+		Proxy: ProxySettings{
+			ListenAddress: "localhost:8082",
+		},
+		Alerts: AlertSettings{
+			Notifiers: []string{"log"},
+		},
+	}
+}
+
+// LoadSettings returns the default settings overridden by the configuration file, if a path is
+// given, and by the environment
+func LoadSettings(path string) (Settings, error) {
+	s := DefaultSettings()
+
+	if path != "" {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return s, fmt.Errorf("failed to read configuration file: %v", err)
+		}
+		if err := yaml.UnmarshalStrict(raw, &s); err != nil {
+			return s, fmt.Errorf("failed to parse configuration file %s: %v", path, err)
+		}
+
+		var given struct {
+			Auth struct {
+				Cloud *string `yaml:"cloud"`
+			} `yaml:"auth"`
+		}
+		if err := yaml.Unmarshal(raw, &given); err == nil && given.Auth.Cloud != nil && *given.Auth.Cloud != "" {
+			s.Auth.cloudSet = true
+		}
+	}
+
+	s.applyEnvironment()
+	return s, nil
+}
+
+// applyEnvironment overrides the settings with the environment variables that are set
+func (s *Settings) applyEnvironment() {
+	for _, setting := range []struct {
+		variable string
+		field    *string
+	}{
+		// the variables read before the AZURE_ ones were introduced, which take precedence over them
+		{"ENVIRONMENT", &s.Auth.Cloud},
+		{"SUBSCRIPTIONID", &s.Target.SubscriptionID},
+		{"RESOURCEGROUPNAME", &s.Target.ResourceGroup},
+		{"LOCATION", &s.Target.Location},
+		{"NAME", &s.Target.Node},
+		{"VMSCALESETNAME", &s.Target.ScaleSet},
+		{"AZURE_ENVIRONMENT", &s.Auth.Cloud},
+		{"AZURE_TENANT_ID", &s.Auth.TenantID},
+		{"AZURE_CLIENT_ID", &s.Auth.ClientID},
+		{"AZURE_CLIENT_SECRET", &s.Auth.ClientSecret},
+		{"AZURE_CLOUD_CONFIG", &s.Auth.CloudConfig},
+		{"AZURE_RESOURCE_MANAGER_ENDPOINT", &s.Auth.ResourceManagerEndpoint},
+		{"AZURE_SUBSCRIPTION_ID", &s.Target.SubscriptionID},
+		{"AZURE_GROUP_NAME", &s.Target.ResourceGroup},
+		{"AZURE_LOCATION_DEFAULT", &s.Target.Location},
+		{"NODE_NAME", &s.Target.Node},
+		{"VMSS_NAME", &s.Target.ScaleSet},
+		{"VMSS_INSTANCE_ID", &s.Target.Instance},
+		{"CLUSTER_NAME", &s.Target.Cluster},
+		{"PROXY_LISTEN_ADDRESS", &s.Proxy.ListenAddress},
+		{"PROXY_UPSTREAM", &s.Proxy.Upstream},
+	} {
+		if value, exists := os.LookupEnv(setting.variable); exists && value != "" {
+			*setting.field = value
+			if setting.field == &s.Auth.Cloud {
+				s.Auth.cloudSet = true
+			}
+		}
+	}
+
+	if value, err := strconv.ParseBool(os.Getenv("AZURE_ANONYMOUS")); err == nil {
+		s.Auth.Anonymous = value
+	}
+
+	s.Outputs.InfluxDB = s.Outputs.InfluxDB.WithEnvironment()
+	s.Outputs.PushGateway = s.Outputs.PushGateway.WithEnvironment()
+	s.Outputs.Prometheus = s.Outputs.Prometheus.WithEnvironment()
+	s.Alerts.Webhook = s.Alerts.Webhook.WithEnvironment()
+}
+
+// Apply makes the settings the global configuration shared by all packages
+func (s Settings) Apply() {
+	cloudName = s.Auth.Cloud
+	environment = nil
+	tenantID = s.Auth.TenantID
+	clientID = s.Auth.ClientID
+	clientSecret = s.Auth.ClientSecret
+	useManagedIdentity = s.Auth.UseManagedIdentity
+	resourceManagerEndpoint = s.Auth.ResourceManagerEndpoint
+	anonymous = s.Auth.Anonymous
+	subscriptionID = s.Target.SubscriptionID
+	groupName = s.Target.ResourceGroup
+	locationDefault = s.Target.Location
+
+	outputs.SetInfluxdbConfig(s.Outputs.InfluxDB)
+	outputs.SetPushGatewayConfig(s.Outputs.PushGateway)
+	outputs.SetPrometheusConfig(s.Outputs.Prometheus)
+	webhook := s.Alerts.Webhook
+	if webhook.MaxWaitSeconds == nil && s.Schedule.PollInterval > 0 {
+		pollInterval := s.Schedule.PollInterval
+		webhook.MaxWaitSeconds = &pollInterval
+	}
+	alerts.SetWebhookConfig(webhook)
+}
+
+// Validate returns every problem of the settings that would otherwise only surface while polling
+func (s Settings) Validate() (problems []error) {
+	report := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, a...))
+	}
+
+	if _, err := azure.EnvironmentFromName(s.Auth.Cloud); err != nil {
+		report("auth.cloud: %v", err)
+	}
+	if s.Auth.ResourceManagerEndpoint != "" {
+		if u, err := url.Parse(s.Auth.ResourceManagerEndpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			report("auth.resourceManagerEndpoint: invalid URL %q", s.Auth.ResourceManagerEndpoint)
+		}
+	}
+	if !s.Auth.UseManagedIdentity && !s.Auth.Anonymous {
+		if s.Auth.ClientSecret != "" && s.Auth.ClientID == "" {
+			report("auth.clientId: required with auth.clientSecret")
+		}
+		if s.Auth.ClientSecret != "" && s.Auth.TenantID == "" {
+			report("auth.tenantId: required with auth.clientSecret")
+		}
+	}
+
+	mode := strings.ToLower(s.Schedule.Mode)
+	if mode != "oneshot" && mode != "service" && mode != "proxy" {
+		report("schedule.mode: unknown mode %q, supported values are: [oneshot|service|proxy]", s.Schedule.Mode)
+	}
+	if mode != "oneshot" && s.Schedule.PollInterval <= 0 {
+		report("schedule.pollInterval: must be a positive number of seconds")
+	}
+
+	// the proxy makes no request of its own
+	if mode == "proxy" {
+		if _, _, err := net.SplitHostPort(s.Proxy.ListenAddress); err != nil {
+			report("proxy.listenAddress: invalid address %q: %v", s.Proxy.ListenAddress, err)
+		}
+		if s.Proxy.Upstream != "" {
+			if u, err := url.Parse(s.Proxy.Upstream); err != nil || u.Scheme == "" || u.Host == "" {
+				report("proxy.upstream: invalid URL %q", s.Proxy.Upstream)
+			}
+		}
+	} else {
+		if s.Target.SubscriptionID == "" {
+			report("target.subscriptionId: required")
+		}
+		if s.Target.ResourceGroup == "" {
+			report("target.resourceGroup: required")
+		}
+		if s.Target.ScaleSet == "" && s.Target.Node == "" {
+			report("target.node: required unless target.vmss is set")
+		}
+		if s.Target.ScaleSet != "" && s.Target.Instance == "" {
+			report("target.vmssInstance: required with target.vmss when it cannot be derived from target.node")
+		}
+	}
+
+	if len(s.Outputs.Targets) == 0 {
+		report("outputs.targets: at least one output is required")
+	}
+	for _, target := range s.Outputs.Targets {
+		var err error
+		switch strings.ToLower(target) {
+		case "influxdb":
+			err = s.Outputs.InfluxDB.Validate(1)
+		case "influxdb2":
+			err = s.Outputs.InfluxDB.Validate(2)
+		case "pushgateway":
+			err = s.Outputs.PushGateway.Validate()
+		case "prometheus":
+			err = s.Outputs.Prometheus.Validate()
+			if err == nil && mode != "service" && mode != "proxy" {
+				err = fmt.Errorf("only supported in service and proxy mode")
+			}
+		default:
+			err = fmt.Errorf("unknown output, supported values are: [%s]", strings.Join(outputs.SinkNames(), "|"))
+		}
+		if err != nil {
+			report("outputs.%s: %v", strings.ToLower(target), err)
+		}
+	}
+	for _, label := range s.Outputs.MetricLabels {
+		if !contains(outputs.LabelNames, label) {
+			report("outputs.metricLabels: unknown label %q, supported values are: [%s]", label, strings.Join(outputs.LabelNames, "|"))
+		}
+	}
+
+	for _, err := range alerts.ValidateRules(s.Alerts.Rules) {
+		report("alerts.%v", err)
+	}
+	notifiers := map[string]bool{}
+	for _, notifier := range s.Alerts.Notifiers {
+		if notifiers[strings.ToLower(notifier)] {
+			report("alerts.notifiers: notifier %q given twice", notifier)
+		}
+		notifiers[strings.ToLower(notifier)] = true
+		switch strings.ToLower(notifier) {
+		case "log":
+		case "webhook":
+			if err := s.Alerts.Webhook.Validate(); err != nil {
+				report("alerts.webhook: %v", err)
+			}
+		default:
+			report("alerts.notifiers: unknown notifier %q, supported values are: [%s]", notifier, strings.Join(alerts.NotifierNames(), "|"))
+		}
+	}
+	return
+}
+
+// Redacted returns a copy of the settings with the secrets replaced, suitable for printing
+func (s Settings) Redacted() Settings {
+	for _, secret := range []*string{
+		&s.Auth.ClientSecret,
+		&s.Outputs.InfluxDB.Password,
+		&s.Outputs.InfluxDB.Token,
+		// the URL of incoming webhooks is their credential
+		&s.Alerts.Webhook.URL,
+	} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	return s
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package alerts
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/outputs"
+)
+
+// Event This struct describes an alert moving from one level to another
+type Event struct {
+	Rule     string
+	Sample   outputs.Sample
+	Previous outputs.AlertLevel
+	Level    outputs.AlertLevel
+	// Threshold is the threshold of the level moved to, or of the previous level once resolved
+	Threshold Threshold
+	Metadata  outputs.Metadata
+	Time      time.Time
+}
+
+// Resolved Reports whether the event is the return of the alert to ok
+func (e Event) Resolved() bool {
+	return e.Level == outputs.AlertOK
+}
+
+type stateKey struct {
+	rule   string
+	bucket string
+}
+
+// state is the level of a rule for a bucket, along with the number of consecutive polls every
+// level was reached or exceeded in, and the same for not being exceeded
+type state struct {
+	bucket  outputs.Bucket
+	level   outputs.AlertLevel
+	atLeast [outputs.AlertCritical + 1]int
+	atMost  [outputs.AlertCritical + 1]int
+}
+
+type compiledRule struct {
+	Rule
+	matcher *regexp.Regexp
+}
+
+// Engine This struct evaluates the alert rules against successive polls, it is not safe for concurrent use
+type Engine struct {
+	rules      []compiledRule
+	states     map[stateKey]*state
+	capacities map[string]int
+	metadata   outputs.Metadata
+	dispatcher *dispatcher
+}
+
+// NewEngine Creates an engine evaluating the rules and sending their events to the notifiers. The
+// metadata describes where the events happen, independently of the labels selected for the outputs.
+func NewEngine(rules []Rule, notifiers []Notifier, metadata outputs.Metadata) *Engine {
+	e := &Engine{
+		states:     map[stateKey]*state{},
+		metadata:   metadata,
+		capacities: map[string]int{},
+		dispatcher: newDispatcher(notifiers),
+	}
+	for _, rule := range rules {
+		e.rules = append(e.rules, compiledRule{rule, rule.matcher()})
+	}
+	return e
+}
+
+// Evaluate Evaluates the rules against the samples of the poll, sets its alerts and notifies the
+// alerts changing level. A bucket missing from the poll keeps the level it had.
+func (e *Engine) Evaluate(poll *outputs.Poll) {
+	samples := poll.Samples()
+	for _, sample := range samples {
+		if sample.Remaining > e.capacities[sample.Bucket.Name] {
+			e.capacities[sample.Bucket.Name] = sample.Remaining
+		}
+	}
+
+	for _, rule := range e.rules {
+		for _, sample := range samples {
+			if !rule.matcher.MatchString(sample.Bucket.Name) {
+				continue
+			}
+			key := stateKey{rule.Name, sample.Bucket.Name}
+			s, exists := e.states[key]
+			if !exists {
+				s = &state{}
+				e.states[key] = s
+			}
+			s.bucket = sample.Bucket
+
+			previous := s.level
+			if !s.observe(rule.Rule, rule.level(sample.Remaining, e.capacity(rule.Rule, sample.Bucket))) {
+				continue
+			}
+			event := Event{
+				Rule:      rule.Name,
+				Sample:    sample,
+				Previous:  previous,
+				Level:     s.level,
+				Threshold: rule.threshold(s.level),
+				Metadata:  e.metadata,
+				Time:      poll.Time,
+			}
+			if event.Resolved() {
+				event.Threshold = rule.threshold(previous)
+			}
+			log.Printf("alert %s for %s moved from %s to %s with %d requests remaining", rule.Name, sample.Bucket.Name, previous, s.level, sample.Remaining)
+			e.dispatcher.send(event)
+		}
+	}
+
+	poll.Alerts = e.alerts()
+}
+
+// Close Waits for the pending notifications to be sent
+func (e *Engine) Close() {
+	e.dispatcher.close()
+}
+
+// alerts Returns the state of every rule for every bucket it applied to, sorted by rule and bucket
+func (e *Engine) alerts() []outputs.Alert {
+	alerts := make([]outputs.Alert, 0, len(e.states))
+	for key, s := range e.states {
+		alerts = append(alerts, outputs.Alert{Rule: key.rule, Bucket: s.bucket, Level: s.level})
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+		return alerts[i].Bucket.Name < alerts[j].Bucket.Name
+	})
+	return alerts
+}
+
+// capacity Returns the capacity of the bucket the percentages of the rule are relative to
+func (e *Engine) capacity(rule Rule, bucket outputs.Bucket) int {
+	if rule.Capacity > 0 {
+		return rule.Capacity
+	}
+	return e.capacities[bucket.Name]
+}
+
+// level Returns the most severe level whose threshold the remaining requests breach
+func (r Rule) level(remaining int, capacity int) outputs.AlertLevel {
+	switch {
+	case r.Critical != nil && r.Critical.breached(remaining, capacity):
+		return outputs.AlertCritical
+	case r.Warning != nil && r.Warning.breached(remaining, capacity):
+		return outputs.AlertWarning
+	default:
+		return outputs.AlertOK
+	}
+}
+
+// threshold Returns the threshold of the level, the zero threshold for ok
+func (r Rule) threshold(level outputs.AlertLevel) Threshold {
+	switch {
+	case level == outputs.AlertCritical && r.Critical != nil:
+		return *r.Critical
+	case level == outputs.AlertWarning && r.Warning != nil:
+		return *r.Warning
+	default:
+		return Threshold{}
+	}
+}
+
+// observe Records the level observed in a poll and reports whether the state moved. It moves up to
+// the most severe level reached or exceeded for as many consecutive polls as the rule requires, so
+// that a bucket going from warning to critical keeps counting towards warning, and down to the
+// least severe level not exceeded for as many consecutive polls.
+func (s *state) observe(rule Rule, observed outputs.AlertLevel) bool {
+	for level := range s.atLeast {
+		if observed >= outputs.AlertLevel(level) {
+			s.atLeast[level]++
+		} else {
+			s.atLeast[level] = 0
+		}
+		if observed <= outputs.AlertLevel(level) {
+			s.atMost[level]++
+		} else {
+			s.atMost[level] = 0
+		}
+	}
+
+	for level := outputs.AlertCritical; level > s.level; level-- {
+		if s.atLeast[level] >= rule.pollsToRaise() {
+			s.level = level
+			return true
+		}
+	}
+	for level := outputs.AlertOK; level < s.level; level++ {
+		if s.atMost[level] >= rule.pollsToLower() {
+			s.level = level
+			return true
+		}
+	}
+	return false
+}
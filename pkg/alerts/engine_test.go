@@ -0,0 +1,167 @@
+package alerts
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/outputs"
+)
+
+const testBucket = "Microsoft.Compute/HighCostGet3Min"
+
+// recorder is a notifier keeping the events it is sent
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) Name() string { return "recorder" }
+
+func (r *recorder) Notify(event Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+// evaluate runs the rule against a poll per value of the bucket, and returns the level of the alert
+// after every poll along with the events sent
+func evaluate(rule Rule, values []int) ([]outputs.AlertLevel, []Event) {
+	notifier := &recorder{}
+	engine := NewEngine([]Rule{rule}, []Notifier{notifier}, outputs.Metadata{})
+	start := time.Date(2020, 6, 15, 10, 0, 0, 0, time.UTC)
+
+	var levels []outputs.AlertLevel
+	for i, value := range values {
+		poll := outputs.Poll{Values: map[string]int{testBucket: value}, Time: start.Add(time.Duration(i) * time.Minute)}
+		engine.Evaluate(&poll)
+		level := outputs.AlertOK
+		for _, alert := range poll.Alerts {
+			if alert.Rule == rule.Name && alert.Bucket.Name == testBucket {
+				level = alert.Level
+			}
+		}
+		levels = append(levels, level)
+	}
+	engine.Close()
+	return levels, notifier.events
+}
+
+func TestEngineLevels(t *testing.T) {
+	const (
+		ok       = outputs.AlertOK
+		warning  = outputs.AlertWarning
+		critical = outputs.AlertCritical
+	)
+	absolute := Rule{Name: "low", Bucket: "Microsoft.Compute/*", Warning: &Threshold{Value: 50}, Critical: &Threshold{Value: 10}}
+	with := func(rule Rule, change func(*Rule)) Rule {
+		change(&rule)
+		return rule
+	}
+
+	tests := []struct {
+		name   string
+		rule   Rule
+		values []int
+		levels []outputs.AlertLevel
+	}{
+		{
+			name:   "immediate",
+			rule:   absolute,
+			values: []int{100, 40, 5, 40, 100},
+			levels: []outputs.AlertLevel{ok, warning, critical, warning, ok},
+		},
+		{
+			name:   "threshold is exclusive",
+			rule:   absolute,
+			values: []int{50, 10},
+			levels: []outputs.AlertLevel{ok, warning},
+		},
+		{
+			name:   "for",
+			rule:   with(absolute, func(r *Rule) { r.For = 3 }),
+			values: []int{40, 40, 100, 40, 40, 40, 100, 100, 100},
+			levels: []outputs.AlertLevel{ok, ok, ok, ok, ok, warning, warning, warning, ok},
+		},
+		{
+			name:   "resolveFor",
+			rule:   with(absolute, func(r *Rule) { r.ResolveFor = 2 }),
+			values: []int{40, 100, 40, 100, 100},
+			levels: []outputs.AlertLevel{warning, warning, warning, warning, ok},
+		},
+		{
+			name:   "warning to critical while pending",
+			rule:   with(absolute, func(r *Rule) { r.For = 2 }),
+			values: []int{40, 5, 5},
+			levels: []outputs.AlertLevel{ok, warning, critical},
+		},
+		{
+			name:   "alternating warning and critical",
+			rule:   with(absolute, func(r *Rule) { r.For = 2 }),
+			values: []int{40, 5, 40, 5, 40},
+			levels: []outputs.AlertLevel{ok, warning, warning, warning, warning},
+		},
+		{
+			name:   "critical to ok through warning",
+			rule:   with(absolute, func(r *Rule) { r.ResolveFor = 2 }),
+			values: []int{5, 40, 100, 100},
+			levels: []outputs.AlertLevel{critical, critical, warning, ok},
+		},
+		{
+			name:   "percentages of the inferred capacity",
+			rule:   Rule{Name: "low", Bucket: testBucket, Warning: &Threshold{Value: 50, Percent: true}, Critical: &Threshold{Value: 10, Percent: true}},
+			values: []int{200, 150, 90, 19, 100},
+			levels: []outputs.AlertLevel{ok, ok, warning, critical, ok},
+		},
+		{
+			name:   "percentages of the given capacity",
+			rule:   Rule{Name: "low", Bucket: testBucket, Warning: &Threshold{Value: 50, Percent: true}, Capacity: 1000},
+			values: []int{200, 600},
+			levels: []outputs.AlertLevel{warning, ok},
+		},
+		{
+			name:   "other buckets",
+			rule:   with(absolute, func(r *Rule) { r.Bucket = "Microsoft.Network/*" }),
+			values: []int{5},
+			levels: []outputs.AlertLevel{ok},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			levels, _ := evaluate(tt.rule, tt.values)
+			if !reflect.DeepEqual(levels, tt.levels) {
+				t.Errorf("levels = %v, want %v", levels, tt.levels)
+			}
+		})
+	}
+}
+
+func TestEngineEvents(t *testing.T) {
+	rule := Rule{Name: "low", Bucket: testBucket, Warning: &Threshold{Value: 50}, Critical: &Threshold{Value: 10}}
+	_, events := evaluate(rule, []int{100, 40, 40, 5, 100})
+
+	want := []struct {
+		previous, level outputs.AlertLevel
+		threshold       Threshold
+		remaining       int
+	}{
+		{outputs.AlertOK, outputs.AlertWarning, *rule.Warning, 40},
+		{outputs.AlertWarning, outputs.AlertCritical, *rule.Critical, 5},
+		{outputs.AlertCritical, outputs.AlertOK, *rule.Critical, 100},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("%d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, event := range events {
+		if event.Rule != rule.Name || event.Previous != want[i].previous || event.Level != want[i].level ||
+			event.Threshold != want[i].threshold || event.Sample.Remaining != want[i].remaining {
+			t.Errorf("events[%d] = %+v, want %+v", i, event, want[i])
+		}
+	}
+	if !events[2].Resolved() {
+		t.Errorf("events[2] is not resolved")
+	}
+}
@@ -0,0 +1,128 @@
+package alerts
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Notifier is a channel the alert events are sent to
+type Notifier interface {
+	Name() string
+	Notify(event Event) error
+}
+
+// NotifierFactory creates a notifier, it is called once at startup
+type NotifierFactory func() (Notifier, error)
+
+var notifiers = map[string]NotifierFactory{}
+
+// RegisterNotifier makes a notifier available under the given name
+func RegisterNotifier(name string, factory NotifierFactory) {
+	if _, exists := notifiers[name]; exists {
+		panic(fmt.Sprintf("notifier %q registered twice", name))
+	}
+	notifiers[name] = factory
+}
+
+// NotifierNames returns the names of all registered notifiers
+func NotifierNames() []string {
+	names := make([]string, 0, len(notifiers))
+	for name := range notifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewNotifiers creates the notifiers with the given names, each at most once
+func NewNotifiers(names []string) ([]Notifier, error) {
+	var created []Notifier
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[strings.ToLower(name)] {
+			return nil, fmt.Errorf("notifier %q given twice", name)
+		}
+		seen[strings.ToLower(name)] = true
+		factory, exists := notifiers[strings.ToLower(name)]
+		if !exists {
+			return nil, fmt.Errorf("unknown notifier %q, supported values are: [%s]", name, strings.Join(NotifierNames(), "|"))
+		}
+		notifier, err := factory()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create notifier %s: %v", name, err)
+		}
+		created = append(created, notifier)
+	}
+	return created, nil
+}
+
+func init() {
+	RegisterNotifier("log", func() (Notifier, error) {
+		return logNotifier{}, nil
+	})
+}
+
+// logNotifier writes the events to the log
+type logNotifier struct{}
+
+func (logNotifier) Name() string {
+	return "log"
+}
+
+func (logNotifier) Notify(event Event) error {
+	if event.Resolved() {
+		log.Printf("RESOLVED %s: %s has %d requests remaining", event.Rule, event.Sample.Bucket.Name, event.Sample.Remaining)
+		return nil
+	}
+	log.Printf("%s %s: %s has %d requests remaining, below %s", strings.ToUpper(event.Level.String()), event.Rule,
+		event.Sample.Bucket.Name, event.Sample.Remaining, event.Threshold)
+	return nil
+}
+
+// queueSize bounds the events waiting to be sent to a notifier, further events are dropped
+const queueSize = 100
+
+// dispatcher sends the events to every notifier in order, without blocking the polls on slow notifiers
+type dispatcher struct {
+	notifiers []Notifier
+	queues    []chan Event
+	wg        sync.WaitGroup
+}
+
+func newDispatcher(notifiers []Notifier) *dispatcher {
+	d := &dispatcher{notifiers: notifiers}
+	for _, notifier := range notifiers {
+		queue := make(chan Event, queueSize)
+		d.queues = append(d.queues, queue)
+		d.wg.Add(1)
+		go func(notifier Notifier) {
+			defer d.wg.Done()
+			for event := range queue {
+				if err := notifier.Notify(event); err != nil {
+					log.Printf("failed to notify %s: %v", notifier.Name(), err)
+				}
+			}
+		}(notifier)
+	}
+	return d
+}
+
+func (d *dispatcher) send(event Event) {
+	for i, queue := range d.queues {
+		select {
+		case queue <- event:
+		default:
+			log.Printf("dropped alert %s for %s, notifier %s is falling behind", event.Rule, event.Sample.Bucket.Name, d.notifiers[i].Name())
+		}
+	}
+}
+
+func (d *dispatcher) close() {
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	d.wg.Wait()
+}
@@ -0,0 +1,150 @@
+package alerts
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Threshold is a number of remaining requests, or a percentage of the capacity of a bucket
+// when written with a % suffix, below which a rule is breached
+type Threshold struct {
+	Value   float64
+	Percent bool
+}
+
+// ParseThreshold Parses a threshold such as "50" or "10%"
+func ParseThreshold(s string) (Threshold, error) {
+	t := Threshold{}
+	value := strings.TrimSpace(s)
+	if strings.HasSuffix(value, "%") {
+		t.Percent = true
+		value = strings.TrimSpace(strings.TrimSuffix(value, "%"))
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || v < 0 || (t.Percent && v > 100) {
+		return t, fmt.Errorf("invalid threshold %q, expected a number of requests or a percentage such as 10%%", s)
+	}
+	t.Value = v
+	return t, nil
+}
+
+func (t Threshold) String() string {
+	value := strconv.FormatFloat(t.Value, 'f', -1, 64)
+	if t.Percent {
+		return value + "%"
+	}
+	return value
+}
+
+// UnmarshalYAML reads the threshold from a number or a string such as "10%"
+func (t *Threshold) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw string
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	parsed, err := ParseThreshold(raw)
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+// MarshalYAML writes the threshold in the form it is read from
+func (t Threshold) MarshalYAML() (interface{}, error) {
+	if !t.Percent {
+		return t.Value, nil
+	}
+	return t.String(), nil
+}
+
+// breached Reports whether the remaining requests are below the threshold, the capacity being
+// required for percentages
+func (t Threshold) breached(remaining int, capacity int) bool {
+	if !t.Percent {
+		return float64(remaining) < t.Value
+	}
+	if capacity <= 0 {
+		return false
+	}
+	return float64(remaining)*100/float64(capacity) < t.Value
+}
+
+// Rule This struct describes when the remaining requests of a bucket are worth alerting on
+type Rule struct {
+	Name string `yaml:"name"`
+	// Bucket is the name of the bucket the rule applies to, or a pattern where * matches any
+	// sequence of characters, e.g. "Microsoft.Compute/HighCostGet*"
+	Bucket string `yaml:"bucket"`
+	// Warning and Critical are the thresholds of each level, at least one of them is required
+	Warning  *Threshold `yaml:"warning,omitempty"`
+	Critical *Threshold `yaml:"critical,omitempty"`
+	// Capacity is the number of requests of a full bucket the percentages are relative to. ARM does
+	// not return it, so the highest number of remaining requests seen is used if it is not given.
+	Capacity int `yaml:"capacity,omitempty"`
+	// For is the number of consecutive polls a level must be reached or exceeded for before the
+	// alert moves up to it, 1 if not given, and ResolveFor the number of consecutive polls it must
+	// not be exceeded for before the alert moves down to it, For if not given
+	For        int `yaml:"for,omitempty"`
+	ResolveFor int `yaml:"resolveFor,omitempty"`
+}
+
+// Validate reports the first problem of the rule
+func (r Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if r.Bucket == "" {
+		return fmt.Errorf("bucket is required")
+	}
+	if r.Warning == nil && r.Critical == nil {
+		return fmt.Errorf("a warning or critical threshold is required")
+	}
+	if r.Warning != nil && r.Critical != nil && r.Warning.Percent == r.Critical.Percent && r.Warning.Value < r.Critical.Value {
+		return fmt.Errorf("the warning threshold %s is below the critical threshold %s", r.Warning, r.Critical)
+	}
+	if r.Capacity < 0 || r.For < 0 || r.ResolveFor < 0 {
+		return fmt.Errorf("capacity, for and resolveFor cannot be negative")
+	}
+	return nil
+}
+
+// ValidateRules reports the problems of every rule, including rules sharing a name, whose states
+// would otherwise be mixed up
+func ValidateRules(rules []Rule) (problems []error) {
+	names := map[string]bool{}
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			problems = append(problems, fmt.Errorf("rules[%d]: %v", i, err))
+		} else if names[rule.Name] {
+			problems = append(problems, fmt.Errorf("rules[%d]: rule %q defined twice", i, rule.Name))
+		}
+		names[rule.Name] = true
+	}
+	return
+}
+
+// matcher Returns a regular expression matching the bucket names of the rule
+func (r Rule) matcher() *regexp.Regexp {
+	parts := strings.Split(r.Bucket, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+func (r Rule) pollsToRaise() int {
+	if r.For <= 0 {
+		return 1
+	}
+	return r.For
+}
+
+func (r Rule) pollsToLower() int {
+	if r.ResolveFor <= 0 {
+		return r.pollsToRaise()
+	}
+	return r.ResolveFor
+}
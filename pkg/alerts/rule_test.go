@@ -0,0 +1,159 @@
+package alerts
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Threshold
+		err   bool
+	}{
+		{input: "50", want: Threshold{Value: 50}},
+		{input: "10%", want: Threshold{Value: 10, Percent: true}},
+		{input: " 12.5 % ", want: Threshold{Value: 12.5, Percent: true}},
+		{input: "0", want: Threshold{}},
+		{input: "", err: true},
+		{input: "%", err: true},
+		{input: "ten", err: true},
+		{input: "-1", err: true},
+		{input: "101%", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseThreshold(tt.input)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseThreshold(%q) = %+v, want an error", tt.input, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseThreshold(%q) = %+v, %v, want %+v", tt.input, got, err, tt.want)
+		}
+	}
+}
+
+func TestThresholdBreached(t *testing.T) {
+	tests := []struct {
+		threshold Threshold
+		remaining int
+		capacity  int
+		want      bool
+	}{
+		{Threshold{Value: 50}, 49, 0, true},
+		{Threshold{Value: 50}, 50, 0, false},
+		{Threshold{Value: 10, Percent: true}, 9, 100, true},
+		{Threshold{Value: 10, Percent: true}, 10, 100, false},
+		{Threshold{Value: 10, Percent: true}, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.threshold.breached(tt.remaining, tt.capacity); got != tt.want {
+			t.Errorf("%s breached(%d, %d) = %v, want %v", tt.threshold, tt.remaining, tt.capacity, got, tt.want)
+		}
+	}
+}
+
+func TestRuleYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want Rule
+		err  string
+	}{
+		{
+			name: "thresholds",
+			yaml: "{name: low, bucket: 'Microsoft.Compute/*', warning: 20%, critical: 10, for: 2}",
+			want: Rule{
+				Name:     "low",
+				Bucket:   "Microsoft.Compute/*",
+				Warning:  &Threshold{Value: 20, Percent: true},
+				Critical: &Threshold{Value: 10},
+				For:      2,
+			},
+		},
+		{name: "invalid threshold", yaml: "{name: low, bucket: '*', warning: lots}", err: "invalid threshold"},
+		{name: "percentage above 100", yaml: "{name: low, bucket: '*', critical: 150%}", err: "invalid threshold"},
+		{name: "unknown field", yaml: "{name: low, bucket: '*', critical: 10, severity: high}", err: "severity"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rule Rule
+			err := yaml.UnmarshalStrict([]byte(tt.yaml), &rule)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rule.Name != tt.want.Name || rule.Bucket != tt.want.Bucket || rule.For != tt.want.For ||
+				*rule.Warning != *tt.want.Warning || *rule.Critical != *tt.want.Critical {
+				t.Errorf("rule = %+v, want %+v", rule, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	ten := &Threshold{Value: 10}
+	twenty := &Threshold{Value: 20}
+	tenPercent := &Threshold{Value: 10, Percent: true}
+
+	tests := []struct {
+		name  string
+		rules []Rule
+		err   string
+	}{
+		{name: "valid", rules: []Rule{{Name: "low", Bucket: "*", Warning: twenty, Critical: ten}}},
+		{name: "warning only", rules: []Rule{{Name: "low", Bucket: "*", Warning: twenty}}},
+		{name: "warning in percent above an absolute critical", rules: []Rule{{Name: "low", Bucket: "*", Warning: tenPercent, Critical: twenty}}},
+		{name: "no name", rules: []Rule{{Bucket: "*", Critical: ten}}, err: "rules[0]: name is required"},
+		{name: "no bucket", rules: []Rule{{Name: "low", Critical: ten}}, err: "rules[0]: bucket is required"},
+		{name: "no threshold", rules: []Rule{{Name: "low", Bucket: "*"}}, err: "threshold is required"},
+		{name: "warning below critical", rules: []Rule{{Name: "low", Bucket: "*", Warning: ten, Critical: twenty}}, err: "below the critical threshold"},
+		{name: "negative for", rules: []Rule{{Name: "low", Bucket: "*", Critical: ten, For: -1}}, err: "cannot be negative"},
+		{
+			name:  "duplicate name",
+			rules: []Rule{{Name: "low", Bucket: "a", Critical: ten}, {Name: "low", Bucket: "b", Critical: ten}},
+			err:   `rules[1]: rule "low" defined twice`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := ValidateRules(tt.rules)
+			if tt.err == "" {
+				if len(problems) > 0 {
+					t.Errorf("problems = %v, want none", problems)
+				}
+				return
+			}
+			if len(problems) != 1 || !strings.Contains(problems[0].Error(), tt.err) {
+				t.Errorf("problems = %v, want one containing %q", problems, tt.err)
+			}
+		})
+	}
+}
+
+func TestRuleMatcher(t *testing.T) {
+	matcher := Rule{Bucket: "Microsoft.Compute/HighCost*"}.matcher()
+	for name, want := range map[string]bool{
+		"Microsoft.Compute/HighCostGet3Min":  true,
+		"Microsoft.Compute/HighCostGet30Min": true,
+		"Microsoft.Compute/LowCostGet3Min":   false,
+		"XMicrosoft.Compute/HighCostGet3Min": false,
+	} {
+		if got := matcher.MatchString(name); got != want {
+			t.Errorf("match %q = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/ratelimit"
+)
+
+// Templates of the bodies of the incoming webhooks of Slack and Microsoft Teams, see WebhookConfig.Format
+const (
+	slackTemplate = `{"text": {{json .Summary}}}`
+	teamsTemplate = `{
+  "@type": "MessageCard",
+  "@context": "https://schema.org/extensions",
+  "themeColor": "{{if eq .Status "resolved"}}2EB67D{{else if eq .Level "critical"}}E01E5A{{else}}ECB22E{{end}}",
+  "summary": {{json .Summary}},
+  "sections": [{
+    "activityTitle": {{json .Summary}},
+    "facts": [
+      {"name": "Bucket", "value": {{json .Bucket}}},
+      {"name": "Remaining", "value": "{{.Remaining}}"},
+      {"name": "Threshold", "value": {{json .Threshold}}}{{if and .Forecast .Remaining}}{{if .Forecast.Exhausts}},
+      {"name": "Exhausted in", "value": "{{printf "%.0f" .Forecast.TimeToExhaustionSeconds}}s"}{{end}}{{end}}{{if .Subscription}},
+      {"name": "Subscription", "value": {{json .Subscription}}}{{end}}{{if .ResourceGroup}},
+      {"name": "Resource group", "value": {{json .ResourceGroup}}}{{end}}{{if .Node}},
+      {"name": "Node", "value": {{json .Node}}}{{end}}
+    ]
+  }]
+}`
+)
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		raw, err := json.Marshal(v)
+		return string(raw), err
+	},
+}
+
+func init() {
+	RegisterNotifier("webhook", func() (Notifier, error) {
+		return NewWebhook(GetWebhookConfig())
+	})
+}
+
+// WebhookConfig This struct contains the endpoint the alert events are posted to and how
+type WebhookConfig struct {
+	URL string `yaml:"url"`
+	// Format is json for the WebhookPayload itself, or slack or teams for a message suited to
+	// their incoming webhooks. It is ignored when a Template is given.
+	Format string `yaml:"format"`
+	// Template is a Go template of the body, executed with the WebhookPayload
+	Template string `yaml:"template,omitempty"`
+	// Retries is the number of times a failed post is retried, waiting BackoffSeconds before the
+	// first retry and twice as long before every next one. They are nil when not given, see WithEnvironment.
+	Retries        *int `yaml:"retries"`
+	BackoffSeconds *int `yaml:"backoffSeconds"`
+	// MaxWaitSeconds bounds the total time waited between the retries of a post, so that the
+	// notifications do not fall behind the polls. It is the poll interval when not given, see
+	// config.Settings.Apply, or defaultMaxWait outside of the limitometer.
+	MaxWaitSeconds *int `yaml:"maxWaitSeconds"`
+}
+
+// defaultMaxWait is the total time waited between the retries of a post when MaxWaitSeconds is nil
+const defaultMaxWait = time.Minute
+
+var webhookConfig *WebhookConfig
+
+// SetWebhookConfig Sets the webhook config used instead of the one generated from environment variables
+func SetWebhookConfig(config WebhookConfig) {
+	webhookConfig = &config
+}
+
+// GetWebhookConfig Returns the webhook config set through SetWebhookConfig, or generates one from
+// environment variables if none was set
+func GetWebhookConfig() WebhookConfig {
+	if webhookConfig != nil {
+		return *webhookConfig
+	}
+	return WebhookConfig{}.WithEnvironment()
+}
+
+// WithEnvironment Returns the webhook config overridden by the environment variables that are set,
+// with defaults for the fields left empty
+func (c WebhookConfig) WithEnvironment() WebhookConfig {
+	for _, setting := range []struct {
+		variable string
+		field    *string
+	}{
+		{"WEBHOOK_URL", &c.URL},
+		{"WEBHOOK_FORMAT", &c.Format},
+		{"WEBHOOK_TEMPLATE", &c.Template},
+	} {
+		if value := os.Getenv(setting.variable); value != "" {
+			*setting.field = value
+		}
+	}
+	for _, setting := range []struct {
+		variable string
+		field    **int
+		fallback int
+	}{
+		{"WEBHOOK_RETRIES", &c.Retries, 3},
+		{"WEBHOOK_BACKOFF_SECONDS", &c.BackoffSeconds, 1},
+	} {
+		if value, err := strconv.Atoi(os.Getenv(setting.variable)); err == nil {
+			*setting.field = &value
+		}
+		if *setting.field == nil {
+			fallback := setting.fallback
+			*setting.field = &fallback
+		}
+	}
+	if value, err := strconv.Atoi(os.Getenv("WEBHOOK_MAX_WAIT_SECONDS")); err == nil {
+		c.MaxWaitSeconds = &value
+	}
+	if c.Format == "" {
+		c.Format = "json"
+	}
+	return c
+}
+
+// Validate reports the first setting preventing the events from being posted
+func (c WebhookConfig) Validate() error {
+	if u, err := url.Parse(c.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid URL %q", c.URL)
+	}
+	if _, err := c.template(); err != nil {
+		return err
+	}
+	for _, field := range []*int{c.Retries, c.BackoffSeconds, c.MaxWaitSeconds} {
+		if field != nil && *field < 0 {
+			return fmt.Errorf("retries, backoffSeconds and maxWaitSeconds cannot be negative")
+		}
+	}
+	return nil
+}
+
+// template Returns the template of the body, nil for the plain payload
+func (c WebhookConfig) template() (*template.Template, error) {
+	text := c.Template
+	if text == "" {
+		switch strings.ToLower(c.Format) {
+		case "json", "":
+			return nil, nil
+		case "slack":
+			text = slackTemplate
+		case "teams":
+			text = teamsTemplate
+		default:
+			return nil, fmt.Errorf("unknown format %q, supported values are: [json|slack|teams]", c.Format)
+		}
+	}
+	t, err := template.New("webhook").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %v", err)
+	}
+	return t, nil
+}
+
+// WebhookForecast This struct is the forecast of the bucket in a WebhookPayload
+type WebhookForecast struct {
+	ConsumptionRate         float64 `json:"consumptionRate"`
+	Exhausts                bool    `json:"exhausts"`
+	TimeToExhaustionSeconds float64 `json:"timeToExhaustionSeconds,omitempty"`
+}
+
+// WebhookPayload This struct is the body posted on every event, or the data of the template
+type WebhookPayload struct {
+	// Status is either firing or resolved
+	Status        string `json:"status"`
+	Rule          string `json:"rule"`
+	Level         string `json:"level"`
+	PreviousLevel string `json:"previousLevel"`
+	// Summary is a one line description of the event, meant for chat messages
+	Summary       string           `json:"summary"`
+	Bucket        string           `json:"bucket"`
+	Provider      string           `json:"provider"`
+	Operation     string           `json:"operation"`
+	Window        string           `json:"window,omitempty"`
+	Scope         string           `json:"scope"`
+	Region        string           `json:"region"`
+	Remaining     int              `json:"remaining"`
+	Threshold     string           `json:"threshold"`
+	Forecast      *WebhookForecast `json:"forecast,omitempty"`
+	Subscription  string           `json:"subscription,omitempty"`
+	ResourceGroup string           `json:"resourceGroup,omitempty"`
+	Cluster       string           `json:"cluster,omitempty"`
+	Node          string           `json:"node,omitempty"`
+	Time          time.Time        `json:"time"`
+}
+
+// NewWebhookPayload Creates the payload of the event
+func NewWebhookPayload(event Event) WebhookPayload {
+	bucket := event.Sample.Bucket
+	p := WebhookPayload{
+		Status:        "firing",
+		Rule:          event.Rule,
+		Level:         event.Level.String(),
+		PreviousLevel: event.Previous.String(),
+		Bucket:        bucket.Name,
+		Provider:      bucket.Provider,
+		Operation:     bucket.Operation,
+		Window:        bucket.WindowLabel(),
+		Scope:         string(bucket.Scope),
+		Region:        bucket.Region,
+		Remaining:     event.Sample.Remaining,
+		Threshold:     event.Threshold.String(),
+		Subscription:  event.Metadata.Subscription,
+		ResourceGroup: event.Metadata.ResourceGroup,
+		Cluster:       event.Metadata.Cluster,
+		Node:          event.Metadata.Node,
+		Time:          event.Time,
+	}
+	if forecast := event.Sample.Forecast; forecast != nil {
+		p.Forecast = &WebhookForecast{
+			ConsumptionRate:         forecast.Rate,
+			Exhausts:                forecast.Exhausts,
+			TimeToExhaustionSeconds: forecast.TimeToExhaustion.Seconds(),
+		}
+	}
+
+	if event.Resolved() {
+		p.Status = "resolved"
+		p.Summary = fmt.Sprintf("[RESOLVED] %s: %s has %d requests remaining, back above %s", p.Rule, p.Bucket, p.Remaining, p.Threshold)
+	} else {
+		p.Summary = fmt.Sprintf("[%s] %s: %s has %d requests remaining, below %s", strings.ToUpper(p.Level), p.Rule, p.Bucket, p.Remaining, p.Threshold)
+	}
+	if p.Forecast != nil && p.Forecast.Exhausts && p.Remaining > 0 && !event.Resolved() {
+		p.Summary += fmt.Sprintf(", exhausted in %.0fs", p.Forecast.TimeToExhaustionSeconds)
+	}
+	return p
+}
+
+// Webhook This struct posts the alert events to an HTTP endpoint
+type Webhook struct {
+	config   WebhookConfig
+	template *template.Template
+	client   *http.Client
+}
+
+// NewWebhook Creates a webhook notifier posting to the configured endpoint
+func NewWebhook(config WebhookConfig) (*Webhook, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	t, _ := config.template()
+	return &Webhook{config: config, template: t, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+// Notify Posts the event, retrying with an exponential backoff on network errors, 429 and 5xx
+// responses. It gives up once the next wait would exceed the maximum total wait.
+func (w *Webhook) Notify(event Event) error {
+	body, err := w.body(NewWebhookPayload(event))
+	if err != nil {
+		return err
+	}
+
+	retries, backoff, maxWait := 0, time.Duration(0), defaultMaxWait
+	if w.config.Retries != nil {
+		retries = *w.config.Retries
+	}
+	if w.config.BackoffSeconds != nil {
+		backoff = time.Duration(*w.config.BackoffSeconds) * time.Second
+	}
+	if w.config.MaxWaitSeconds != nil {
+		maxWait = time.Duration(*w.config.MaxWaitSeconds) * time.Second
+	}
+	waited := time.Duration(0)
+	for attempt := 0; ; attempt++ {
+		wait, err := w.post(body)
+		if err == nil {
+			log.Printf("Successfully notified webhook of alert %s for %s", event.Rule, event.Sample.Bucket.Name)
+			return nil
+		}
+		if wait < 0 || attempt >= retries {
+			return err
+		}
+		if wait < backoff {
+			wait = backoff
+		}
+		if waited+wait > maxWait {
+			return fmt.Errorf("giving up after %d attempts, the next retry would exceed the maximum wait of %s: %v", attempt+1, maxWait, err)
+		}
+		log.Printf("failed to notify webhook, retrying in %s: %v", wait, err)
+		time.Sleep(wait)
+		waited += wait
+		backoff *= 2
+	}
+}
+
+// body Returns the payload rendered by the template, or as JSON without template
+func (w *Webhook) body(payload WebhookPayload) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(payload)
+	}
+	var buf bytes.Buffer
+	if err := w.template.Execute(&buf, payload); err != nil {
+		return nil, fmt.Errorf("failed to render webhook template: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// post Posts the body once. On failure, it returns how long the endpoint asked to wait before
+// retrying, 0 if it did not say, or a negative duration if the post must not be retried.
+func (w *Webhook) post(body []byte) (time.Duration, error) {
+	resp, err := w.client.Post(w.config.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		retryAfter, _ := ratelimit.RetryAfter(resp.Header, time.Now())
+		return retryAfter, fmt.Errorf("webhook returned %s", resp.Status)
+	case resp.StatusCode >= 500:
+		return 0, fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return -1, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+}
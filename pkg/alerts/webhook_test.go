@@ -0,0 +1,168 @@
+package alerts
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/outputs"
+)
+
+// webhookServer answers the posts with the given statuses in turn, the last one repeated, and
+// keeps the bodies it receives
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	header   http.Header
+	bodies   []WebhookPayload
+}
+
+func newWebhookServer(t *testing.T, header http.Header, statuses ...int) *webhookServer {
+	s := &webhookServer{statuses: statuses, header: header}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		var payload WebhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("invalid body: %v", err)
+		}
+		s.bodies = append(s.bodies, payload)
+
+		status := s.statuses[0]
+		if len(s.statuses) > 1 {
+			s.statuses = s.statuses[1:]
+		}
+		for name, values := range s.header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webhookServer) posts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.bodies)
+}
+
+func testEvent() Event {
+	return Event{
+		Rule:      "low",
+		Sample:    outputs.Sample{Bucket: outputs.ParseBucket(testBucket), Remaining: 5},
+		Previous:  outputs.AlertWarning,
+		Level:     outputs.AlertCritical,
+		Threshold: Threshold{Value: 10},
+		Time:      time.Date(2020, 6, 15, 10, 0, 0, 0, time.UTC),
+	}
+}
+
+func intPointer(value int) *int {
+	return &value
+}
+
+func TestWebhookNotify(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		header   http.Header
+		retries  int
+		maxWait  int
+		posts    int
+		err      string
+	}{
+		{name: "success", statuses: []int{http.StatusOK}, retries: 3, posts: 1},
+		{name: "retry on 5xx", statuses: []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK}, retries: 3, posts: 3},
+		{name: "retry on 429", statuses: []int{http.StatusTooManyRequests, http.StatusOK}, retries: 3, posts: 2},
+		{name: "retries exhausted", statuses: []int{http.StatusInternalServerError}, retries: 2, posts: 3, err: "500"},
+		{name: "retries disabled", statuses: []int{http.StatusServiceUnavailable, http.StatusOK}, retries: 0, posts: 1, err: "503"},
+		{name: "no retry on 4xx", statuses: []int{http.StatusBadRequest, http.StatusOK}, retries: 3, posts: 1, err: "400"},
+		{
+			name:     "Retry-After beyond the maximum wait",
+			statuses: []int{http.StatusTooManyRequests, http.StatusOK},
+			header:   http.Header{"Retry-After": []string{"120"}},
+			retries:  3,
+			maxWait:  60,
+			posts:    1,
+			err:      "maximum wait",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newWebhookServer(t, tt.header, tt.statuses...)
+			webhook, err := NewWebhook(WebhookConfig{
+				URL:            server.URL,
+				Format:         "json",
+				Retries:        intPointer(tt.retries),
+				BackoffSeconds: intPointer(0),
+				MaxWaitSeconds: intPointer(tt.maxWait),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = webhook.Notify(testEvent())
+			if tt.err == "" && err != nil {
+				t.Errorf("Notify() = %v, want no error", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("Notify() = %v, want an error containing %q", err, tt.err)
+			}
+			if posts := server.posts(); posts != tt.posts {
+				t.Errorf("%d posts, want %d", posts, tt.posts)
+			}
+		})
+	}
+}
+
+func TestWebhookPayload(t *testing.T) {
+	server := newWebhookServer(t, nil, http.StatusOK)
+	webhook, err := NewWebhook(WebhookConfig{URL: server.URL, Format: "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := webhook.Notify(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := server.bodies[0]
+	if payload.Status != "firing" || payload.Level != "critical" || payload.PreviousLevel != "warning" ||
+		payload.Bucket != testBucket || payload.Remaining != 5 || payload.Threshold != "10" || payload.Window != "3m" {
+		t.Errorf("payload = %+v", payload)
+	}
+}
+
+func TestWebhookConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config WebhookConfig
+		err    string
+	}{
+		{name: "valid", config: WebhookConfig{URL: "https://example.com/hook", Format: "teams"}},
+		{name: "no URL", config: WebhookConfig{}, err: "invalid URL"},
+		{name: "unknown format", config: WebhookConfig{URL: "https://example.com/hook", Format: "irc"}, err: "unknown format"},
+		{name: "invalid template", config: WebhookConfig{URL: "https://example.com/hook", Template: "{{.Rule"}, err: "invalid template"},
+		{name: "negative retries", config: WebhookConfig{URL: "https://example.com/hook", Retries: intPointer(-1)}, err: "cannot be negative"},
+		{name: "negative maximum wait", config: WebhookConfig{URL: "https://example.com/hook", MaxWaitSeconds: intPointer(-1)}, err: "cannot be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.err == "" && err != nil {
+				t.Errorf("Validate() = %v, want no error", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package common
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/compute/mgmt/compute"
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/network/mgmt/network"
+)
+
+// VirtualMachinesAPI is the part of compute.VirtualMachinesClient used by AzureClient
+type VirtualMachinesAPI interface {
+	Get(ctx context.Context, resourceGroupName string, VMName string, expand compute.InstanceViewTypes) (compute.VirtualMachine, error)
+	List(ctx context.Context, resourceGroupName string) (compute.VirtualMachineListResultPage, error)
+	CreateOrUpdate(ctx context.Context, resourceGroupName string, VMName string, parameters compute.VirtualMachine) (compute.VirtualMachinesCreateOrUpdateFuture, error)
+}
+
+// InterfacesAPI is the part of network.InterfacesClient used by AzureClient
+type InterfacesAPI interface {
+	Get(ctx context.Context, resourceGroupName string, networkInterfaceName string, expand string) (network.Interface, error)
+	List(ctx context.Context, resourceGroupName string) (network.InterfaceListResultPage, error)
+}
+
+// LoadBalancersAPI is the part of network.LoadBalancersClient used by AzureClient
+type LoadBalancersAPI interface {
+	List(ctx context.Context, resourceGroupName string) (network.LoadBalancerListResultPage, error)
+}
+
+// ScaleSetsAPI is the part of compute.VirtualMachineScaleSetsClient used by AzureClient
+type ScaleSetsAPI interface {
+	Get(ctx context.Context, resourceGroupName string, VMScaleSetName string) (compute.VirtualMachineScaleSet, error)
+	List(ctx context.Context, resourceGroupName string) (compute.VirtualMachineScaleSetListResultPage, error)
+}
+
+// ScaleSetVMsAPI is the part of compute.VirtualMachineScaleSetVMsClient used by AzureClient
+type ScaleSetVMsAPI interface {
+	Get(ctx context.Context, resourceGroupName string, VMScaleSetName string, instanceID string, expand compute.InstanceViewTypes) (compute.VirtualMachineScaleSetVM, error)
+	List(ctx context.Context, resourceGroupName string, virtualMachineScaleSetName string, filter string, selectParameter string, expand string) (compute.VirtualMachineScaleSetVMListResultPage, error)
+	Update(ctx context.Context, resourceGroupName string, VMScaleSetName string, instanceID string, parameters compute.VirtualMachineScaleSetVM) (compute.VirtualMachineScaleSetVMsUpdateFuture, error)
+}
@@ -1,14 +1,11 @@
 package common
 
 import (
-
 	"context"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
-	"time"
-	"github.com/hetalsonavane/azure-request-limitometer/internal/config"
+
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/compute/mgmt/compute"
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/network/mgmt/network"
 	"github.com/Azure/go-autorest/autorest"
@@ -17,123 +14,161 @@ import (
 	"github.com/hetalsonavane/azure-request-limitometer/internal/config"
 )
 
-// AzureClient This is an authorized client for Azure communication.
-type AzureClient struct {
-	compute.VirtualMachinesClient
-	network.InterfacesClient
-	network.LoadBalancersClient
+// ClientConfig selects the cloud, subscription and resource group the requests are made against
+type ClientConfig struct {
+	ResourceManagerEndpoint string
+	SubscriptionID          string
+	ResourceGroup           string
+	UserAgent               string
 }
 
-// NewClient Initialized an authorized Azure client
-func NewClient() (client AzureClient) {
-	fmt.Println(config.ClientID())
-	client = AzureClient{
-		GetVmClient(),
-		GetNicClient(),
-		GetLbClient(),
+// DefaultClientConfig returns the client config of the global configuration
+func DefaultClientConfig() ClientConfig {
+	return ClientConfig{
+		ResourceManagerEndpoint: config.ResourceManagerEndpoint(),
+		SubscriptionID:          config.SubscriptionID(),
+		ResourceGroup:           config.GroupName(),
+		UserAgent:               config.UserAgent(),
 	}
-	return
 }
 
-//GetVmClient return vmClient
-func GetVmClient() compute.VirtualMachinesClient {
-	//fmt.Println(config.SubscriptionID())
-	vmClient := compute.NewVirtualMachinesClient(config.SubscriptionID())
-	a, err := auth.NewAuthorizerFromEnvironment()
-	if err != nil {
-		log.Fatalf("failed to create authorizer from environment: %s\n", err)
-	}
-	vmClient.Authorizer = a
-	vmClient.AddToUserAgent(config.UserAgent())
-	return vmClient
+// AzureClient This is an authorized client for Azure communication. The API clients can be
+// replaced with fakes.
+type AzureClient struct {
+	Config          ClientConfig
+	VirtualMachines VirtualMachinesAPI
+	Interfaces      InterfacesAPI
+	LoadBalancers   LoadBalancersAPI
+	ScaleSets       ScaleSetsAPI
+	ScaleSetVMs     ScaleSetVMsAPI
+	// Resources sends the requests against arbitrary ARM resources
+	Resources autorest.Client
 }
 
-// GetNicClient return nic client
-func GetNicClient() network.InterfacesClient {
-	nicClient := network.NewInterfacesClient(config.SubscriptionID())
-	a, err := auth.NewAuthorizerFromEnvironment()
-	if err != nil {
-		log.Fatalf("failed to create authorizer from environment: %s\n", err)
+// noRetries makes the API clients send every request once. The SDK otherwise retries throttled
+// requests until their Retry-After elapses, while the probes need to observe the throttling.
+var noRetries = []autorest.SendDecorator{}
+
+// NewClient Initializes an Azure client whose API clients share the given authorizer
+func NewClient(cfg ClientConfig, authorizer autorest.Authorizer) AzureClient {
+	vmClient := compute.NewVirtualMachinesClientWithBaseURI(cfg.ResourceManagerEndpoint, cfg.SubscriptionID)
+	vmClient.Authorizer = authorizer
+	vmClient.AddToUserAgent(cfg.UserAgent)
+	vmClient.SendDecorators = noRetries
+
+	nicClient := network.NewInterfacesClientWithBaseURI(cfg.ResourceManagerEndpoint, cfg.SubscriptionID)
+	nicClient.Authorizer = authorizer
+	nicClient.AddToUserAgent(cfg.UserAgent)
+	nicClient.SendDecorators = noRetries
+
+	lbClient := network.NewLoadBalancersClientWithBaseURI(cfg.ResourceManagerEndpoint, cfg.SubscriptionID)
+	lbClient.Authorizer = authorizer
+	lbClient.AddToUserAgent(cfg.UserAgent)
+	lbClient.SendDecorators = noRetries
+
+	vmssClient := compute.NewVirtualMachineScaleSetsClientWithBaseURI(cfg.ResourceManagerEndpoint, cfg.SubscriptionID)
+	vmssClient.Authorizer = authorizer
+	vmssClient.AddToUserAgent(cfg.UserAgent)
+	vmssClient.SendDecorators = noRetries
+
+	vmssVMClient := compute.NewVirtualMachineScaleSetVMsClientWithBaseURI(cfg.ResourceManagerEndpoint, cfg.SubscriptionID)
+	vmssVMClient.Authorizer = authorizer
+	vmssVMClient.AddToUserAgent(cfg.UserAgent)
+	vmssVMClient.SendDecorators = noRetries
+
+	resources := autorest.NewClientWithUserAgent(cfg.UserAgent)
+	resources.Authorizer = authorizer
+
+	return AzureClient{
+		Config:          cfg,
+		VirtualMachines: vmClient,
+		Interfaces:      nicClient,
+		LoadBalancers:   lbClient,
+		ScaleSets:       vmssClient,
+		ScaleSetVMs:     vmssVMClient,
+		Resources:       resources,
 	}
-	nicClient.Authorizer = a
-	nicClient.AddToUserAgent(config.UserAgent())
-	return nicClient
 }
 
-// GetLbClient return LB client
-func GetLbClient() network.LoadBalancersClient {
-	lbClient := network.NewLoadBalancersClient(config.SubscriptionID())
-	a, err := auth.NewAuthorizerFromEnvironment()
-	if err != nil {
-		log.Fatalf("failed to create authorizer from environment: %s\n", err)
+// NewAuthorizer returns an authorizer for the credentials of the configuration, falling back
+// to the settings of the environment understood by the SDK. A single authorizer should be
+// shared by every client of the process so that the token is only acquired once.
+func NewAuthorizer() (autorest.Authorizer, error) {
+	if config.Anonymous() {
+		return autorest.NullAuthorizer{}, nil
 	}
-	lbClient.Authorizer = a
-	lbClient.AddToUserAgent(config.UserAgent())
-	return lbClient
+	env := config.Environment()
+	if config.UseManagedIdentity() {
+		msi := auth.NewMSIConfig()
+		msi.Resource = env.ResourceManagerEndpoint
+		msi.ClientID = config.ClientID()
+		return msi.Authorizer()
+	}
+	if config.ClientID() != "" && config.ClientSecret() != "" {
+		credentials := auth.NewClientCredentialsConfig(config.ClientID(), config.ClientSecret(), config.TenantID())
+		credentials.AADEndpoint = env.ActiveDirectoryEndpoint
+		credentials.Resource = env.ResourceManagerEndpoint
+		return credentials.Authorizer()
+	}
+	return auth.NewAuthorizerFromEnvironment()
 }
 
 // GetVM Returns a VirtualMachine object.
 func (az AzureClient) GetVM(ctx context.Context, nodename string) (compute.VirtualMachine, error) {
-	client := GetVmClient()
-	//	fmt.Printf("VM")
-	return client.Get(ctx, config.GroupName(), nodename, compute.InstanceView)
+	return az.VirtualMachines.Get(ctx, az.Config.ResourceGroup, nodename, compute.InstanceView)
 }
 
 // GetAllLoadBalancer return info on a loadbalancer
-func (az AzureClient) GetAllLoadBalancer() (network.LoadBalancerListResultPage, error) {
-	lbClient := GetLbClient()
-	ctx, cancel := context.WithTimeout(context.Background(), 6000*time.Second)
-	defer cancel()
-	return lbClient.List(ctx, config.GroupName())
+func (az AzureClient) GetAllLoadBalancer(ctx context.Context) (network.LoadBalancerListResultPage, error) {
+	return az.LoadBalancers.List(ctx, az.Config.ResourceGroup)
 }
 
 // GetNicFromVMName returns primary nic object based on vm name
-func (az AzureClient) GetNicFromVMName(nodename string) (network.Interface, error) {
-	return az.getNic(nodename, true)
+func (az AzureClient) GetNicFromVMName(ctx context.Context, nodename string) (network.Interface, error) {
+	return az.getNic(ctx, nodename, true)
 }
 
 // getNic return a nic object
-func (az AzureClient) getNic(resource string, vmResource bool) (network.Interface, error) {
-
-	client := GetNicClient()
+func (az AzureClient) getNic(ctx context.Context, resource string, vmResource bool) (network.Interface, error) {
 	if vmResource {
-		resource = az.getNicNameFromVMName(resource)
-		//	fmt.Println("Nic", resource)
+		vm, err := az.GetVM(ctx, resource)
+		if err != nil {
+			return network.Interface{Response: vm.Response}, fmt.Errorf("failed to getVM: %v", err)
+		}
+		resource, err = getNicNameFromVM(vm)
+		if err != nil {
+			return network.Interface{}, err
+		}
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 6000*time.Second)
-	defer cancel()
-	return client.Get(ctx, config.GroupName(), resource, "")
+	return az.Interfaces.Get(ctx, az.Config.ResourceGroup, resource, "")
 }
 
-// getNicNameFromVMName return a nicname from VM
-func (az AzureClient) getNicNameFromVMName(nodename string) string {
-	vm, error := az.GetVM(context.Background(), nodename)
-	if error != nil {
-		fmt.Printf("failed to getVM: %v", error)
-	}
+// getNicNameFromVM return a nicname from VM
+func getNicNameFromVM(vm compute.VirtualMachine) (string, error) {
 	primaryNicID, err := getPrimaryInterfaceID(vm)
-
 	if err != nil {
-		fmt.Printf("failed to getPrimaryInterfaceID from VM: %v", err)
+		return "", fmt.Errorf("failed to getPrimaryInterfaceID from VM: %v", err)
 	}
 
 	nicName, err := getLastSegment(primaryNicID)
-
 	if err != nil {
-		fmt.Printf("failed to nic name from nicID: %v", err)
+		return "", fmt.Errorf("failed to nic name from nicID: %v", err)
 	}
 
-	return nicName
+	return nicName, nil
 }
 
 // This returns the full identifier of the primary NIC for the given VM.
 func getPrimaryInterfaceID(machine compute.VirtualMachine) (string, error) {
+	if machine.NetworkProfile == nil || machine.NetworkProfile.NetworkInterfaces == nil {
+		return "", fmt.Errorf("no network interface found for the vm")
+	}
 	if len(*machine.NetworkProfile.NetworkInterfaces) == 1 {
 		return *(*machine.NetworkProfile.NetworkInterfaces)[0].ID, nil
 	}
 
 	for _, ref := range *machine.NetworkProfile.NetworkInterfaces {
-		if *ref.Primary {
+		if ref.Primary != nil && *ref.Primary {
 			return *ref.ID, nil
 		}
 	}
@@ -152,53 +187,81 @@ func getLastSegment(ID string) (string, error) {
 }
 
 // GetAllVM Returns a ListResultPage of all VMs in the ResourceGroup of the Config
-func (az AzureClient) GetAllVM() (result compute.VirtualMachineListResultPage) {
-	client := GetVmClient()
-	ctx, cancel := context.WithTimeout(context.Background(), 6000*time.Second)
-	defer cancel()
-	//fmt.Println("GetAllVM")
-	result, err := client.List(ctx, config.GroupName())
-	if err != nil {
-		log.Panicf("failed to get all VMs: %v", err)
-	}
-	return
+func (az AzureClient) GetAllVM(ctx context.Context) (compute.VirtualMachineListResultPage, error) {
+	return az.VirtualMachines.List(ctx, az.Config.ResourceGroup)
 }
 
 // PutVM returns the Virtual Machine object
-func (az AzureClient) PutVM(nodename string) (res autorest.Response) {
-	ctx, cancel := context.WithTimeout(context.Background(), 6000*time.Second)
-	defer cancel()
-	//fmt.Println("PutVM")
+func (az AzureClient) PutVM(ctx context.Context, nodename string) (autorest.Response, error) {
 	node, err := az.GetVM(ctx, nodename)
 	if err != nil {
-		log.Panic(err)
+		return node.Response, err
 	}
-	req, err := az.VirtualMachinesClient.CreateOrUpdatePreparer(ctx, config.GroupName(), nodename, node)
+	future, err := az.VirtualMachines.CreateOrUpdate(ctx, az.Config.ResourceGroup, nodename, node)
+	return autorest.Response{Response: future.Response()}, err
+}
+
+// GetAllNics Returns a ListResultPage of all Interfaces in the ResourceGroup of the Config
+func (az AzureClient) GetAllNics(ctx context.Context) (network.InterfaceListResultPage, error) {
+	return az.Interfaces.List(ctx, az.Config.ResourceGroup)
+}
+
+// GetResource performs a GET against an arbitrary ARM resource path. The
+// placeholders {subscriptionId} and {resourceGroupName} are substituted from
+// the configuration.
+func (az AzureClient) GetResource(ctx context.Context, path string, apiVersion string) (autorest.Response, error) {
+	path = strings.NewReplacer(
+		"{subscriptionId}", az.Config.SubscriptionID,
+		"{resourceGroupName}", az.Config.ResourceGroup,
+	).Replace(path)
+
+	req, err := autorest.Prepare((&http.Request{}).WithContext(ctx),
+		autorest.AsGet(),
+		autorest.WithBaseURL(az.Config.ResourceManagerEndpoint),
+		autorest.WithPath(path),
+		autorest.WithQueryParameters(map[string]interface{}{"api-version": apiVersion}))
 	if err != nil {
-		log.Panic(err)
+		return autorest.Response{}, fmt.Errorf("failed to prepare request for %s: %v", path, err)
 	}
 
-	var result *http.Response
-	result, err = autorest.SendWithSender(az.VirtualMachinesClient, req,
-		azure.DoRetryWithRegistration(az.VirtualMachinesClient.Client))
-	err = autorest.Respond(result, azure.WithErrorUnlessStatusCode(http.StatusOK, http.StatusCreated))
+	// the client authorizes the request when sending it
+	resp, err := az.Resources.Send(req)
 	if err != nil {
-		log.Panic(err)
+		return autorest.Response{Response: resp}, err
 	}
-	res.Response = result
+	err = autorest.Respond(resp,
+		azure.WithErrorUnlessStatusCode(http.StatusOK),
+		autorest.ByDiscardingBody(),
+		autorest.ByClosing())
+	return autorest.Response{Response: resp}, err
+}
 
-	return
+// GetVMSS Returns a VirtualMachineScaleSet object
+func (az AzureClient) GetVMSS(ctx context.Context, vmssName string) (compute.VirtualMachineScaleSet, error) {
+	return az.ScaleSets.Get(ctx, az.Config.ResourceGroup, vmssName)
 }
 
-// GetAllNics Returns a ListResultPage of all Interfaces in the ResourceGroup of the Config
-func (az AzureClient) GetAllNics() network.InterfaceListResultPage {
-	client := GetNicClient()
-	ctx, cancel := context.WithTimeout(context.Background(), 6000*time.Second)
-	defer cancel()
-	result, err := client.List(ctx, config.GroupName())
+// GetAllVMSS Returns a ListResultPage of all VM Scale Sets in the ResourceGroup of the Config
+func (az AzureClient) GetAllVMSS(ctx context.Context) (compute.VirtualMachineScaleSetListResultPage, error) {
+	return az.ScaleSets.List(ctx, az.Config.ResourceGroup)
+}
+
+// GetVMSSVM Returns a VirtualMachineScaleSetVM object of the given instance
+func (az AzureClient) GetVMSSVM(ctx context.Context, vmssName string, instanceID string) (compute.VirtualMachineScaleSetVM, error) {
+	return az.ScaleSetVMs.Get(ctx, az.Config.ResourceGroup, vmssName, instanceID, compute.InstanceView)
+}
+
+// GetAllVMSSVMs Returns a ListResultPage of all instances of the VM Scale Set
+func (az AzureClient) GetAllVMSSVMs(ctx context.Context, vmssName string) (compute.VirtualMachineScaleSetVMListResultPage, error) {
+	return az.ScaleSetVMs.List(ctx, az.Config.ResourceGroup, vmssName, "", "", "")
+}
+
+// PutVMSSVM Updates the instance of the VM Scale Set with its current model and returns the initial response
+func (az AzureClient) PutVMSSVM(ctx context.Context, vmssName string, instanceID string) (autorest.Response, error) {
+	vm, err := az.ScaleSetVMs.Get(ctx, az.Config.ResourceGroup, vmssName, instanceID, "")
 	if err != nil {
-		log.Printf("failed to get all Interfaces; check HTTP_PROXY: %v", err)
+		return vm.Response, err
 	}
-
-	return result
+	future, err := az.ScaleSetVMs.Update(ctx, az.Config.ResourceGroup, vmssName, instanceID, vm)
+	return autorest.Response{Response: future.Response()}, err
 }
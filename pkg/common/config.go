@@ -1,8 +1,15 @@
 package common
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/Azure/go-autorest/autorest/azure"
 )
@@ -10,59 +17,100 @@ import (
 const apiVersion = "2018-10-01"
 const azureInstanceMetadataEndpoint = "http://169.254.169.254/metadata/instance"
 
+// InstanceMetadataEndpoint returns the Azure Instance Metadata Service endpoint, which can be
+// pointed at a local stand-in through AZURE_INSTANCE_METADATA_ENDPOINT
+func InstanceMetadataEndpoint() string {
+	if endpoint := os.Getenv("AZURE_INSTANCE_METADATA_ENDPOINT"); endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/")
+	}
+	return azureInstanceMetadataEndpoint
+}
+
 // Queries the Azure Instance Metadata Service for the instance's compute metadata
-/*
-func retrieveComputeInstanceMetadata() (metadata ComputeInstanceMetadata, err error) {
-	c := &http.Client{}
+func retrieveComputeInstanceMetadata(ctx context.Context) (metadata ComputeInstanceMetadata, err error) {
+	c := &http.Client{
+		// IMDS is not reachable through a proxy
+		Transport: &http.Transport{Proxy: nil},
+	}
 
-	req, _ := http.NewRequest("GET", azureInstanceMetadataEndpoint+"/compute", nil)
+	req, err := http.NewRequest("GET", InstanceMetadataEndpoint()+"/compute", nil)
+	if err != nil {
+		return
+	}
 	req.Header.Add("Metadata", "True")
 	q := req.URL.Query()
 	q.Add("format", "json")
 	q.Add("api-version", apiVersion)
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := c.Do(req)
+	resp, err := c.Do(req.WithContext(ctx))
 	if err != nil {
 		err = fmt.Errorf("sending Azure Instance Metadata Service request failed: %v", err)
+		return
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("Azure Instance Metadata Service returned StatusCode %d", resp.StatusCode)
+		return
+	}
+
 	rawJSON, err := ioutil.ReadAll(resp.Body)
 
 	if err != nil {
 		err = fmt.Errorf("reading response body failed: %v", err)
 		return
 	}
-	if err := json.Unmarshal(rawJSON, &metadata); err != nil {
+	if err = json.Unmarshal(rawJSON, &metadata); err != nil {
 		err = fmt.Errorf("unmarshaling JSON response failed: %v", err)
 	}
 
 	return
 }
-*/
-func retrieveenvdata() JsonData {
-
-	config := JsonData{
-		Name:              os.Getenv("NAME"),
-		SubscriptionID:    os.Getenv("SUBSCRIPTIONID"),
-		Location:          os.Getenv("LOCATION"),
-		ResourceGroupName: os.Getenv("RESOURCEGROUPNAME"),
-		Environment:       os.Getenv("ENVIRONMENT"),
+
+// fillFromInstanceMetadata sets the empty fields from the instance metadata
+func (m *JsonData) fillFromInstanceMetadata(metadata ComputeInstanceMetadata) {
+	fill := func(field *string, value string) {
+		if *field == "" {
+			*field = value
+		}
 	}
-	return config
+	fill(&m.Name, metadata.Name)
+	fill(&m.VMScaleSetName, metadata.VMScaleSetName)
+	fill(&m.SubscriptionID, metadata.SubscriptionID)
+	fill(&m.Location, metadata.Location)
+	fill(&m.ResourceGroupName, metadata.ResourceGroupName)
+	fill(&m.Environment, metadata.Environment)
 }
 
-// LoadConfig Returns a Config struct created from Environment Variables
-func LoadConfig() (config Config) {
-	m := retrieveenvdata()
+// LoadConfig Returns a Config struct discovered through the Azure Instance Metadata Service when
+// discover is true. The fields are left empty when it is false or the service cannot be reached.
+func LoadConfig(discover bool) (config Config, err error) {
+	var m JsonData
+
+	if discover {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		metadata, err := retrieveComputeInstanceMetadata(ctx)
+		if err != nil {
+			log.Printf("failed to discover configuration through Azure Instance Metadata Service: %v", err)
+		} else {
+			m.fillFromInstanceMetadata(metadata)
+		}
+	}
 
+	if m.Environment == "" {
+		m.Environment = azure.PublicCloud.Name
+	}
 	env, err := azure.EnvironmentFromName(m.Environment)
 	if err != nil {
 		err = fmt.Errorf("Could not get environment object from metadata name: %v", err)
+		return
 	}
 	config = Config{
 		VMName:              m.Name,
+		VMScaleSetName:      m.VMScaleSetName,
 		SubscriptionID:      m.SubscriptionID,
 		Location:            m.Location,
 		ResourceGroup:       m.ResourceGroupName,
@@ -72,3 +120,21 @@ func LoadConfig() (config Config) {
 
 	return
 }
+
+// InstanceMetadataHandler serves the given metadata like the compute endpoint of the Azure
+// Instance Metadata Service. It is a local stand-in for development and tests, to be used
+// together with AZURE_INSTANCE_METADATA_ENDPOINT.
+func InstanceMetadataHandler(metadata ComputeInstanceMetadata) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "True" {
+			http.Error(w, "Required metadata header not specified", http.StatusBadRequest)
+			return
+		}
+		if !strings.HasSuffix(r.URL.Path, "/compute") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(metadata)
+	})
+}
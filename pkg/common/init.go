@@ -1,10 +0,0 @@
-package common
-
-// Conf Loaded subscriptionId
-
-// Client Authorized Azure Client
-var Client AzureClient
-
-func init() {
-	Client = NewClient()
-}
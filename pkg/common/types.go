@@ -29,6 +29,7 @@ type ComputeInstanceMetadata struct {
 // Config returns a config with the Azure resource group and Azure location to perform requests
 type Config struct {
 	VMName              string
+	VMScaleSetName      string
 	SubscriptionID      string
 	Location            string
 	ResourceGroup       string
@@ -39,6 +40,7 @@ type Config struct {
 //JsonData returns a azure config
 type JsonData struct {
 	Name              string
+	VMScaleSetName    string
 	SubscriptionID    string
 	Location          string
 	ResourceGroupName string
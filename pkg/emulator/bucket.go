@@ -0,0 +1,74 @@
+package emulator
+
+import (
+	"math"
+	"time"
+)
+
+// Header names the remaining requests of a bucket are returned under. Buckets returned under
+// HeaderResource are joined in a single header as name;remaining pairs, the others are
+// returned in a header of their own.
+const (
+	HeaderResource                 = "x-ms-ratelimit-remaining-resource"
+	HeaderSubscriptionReads        = "x-ms-ratelimit-remaining-subscription-reads"
+	HeaderSubscriptionWrites       = "x-ms-ratelimit-remaining-subscription-writes"
+	HeaderSubscriptionGlobalReads  = "x-ms-ratelimit-remaining-subscription-global-reads"
+	HeaderSubscriptionGlobalWrites = "x-ms-ratelimit-remaining-subscription-global-writes"
+	HeaderTenantReads              = "x-ms-ratelimit-remaining-tenant-reads"
+)
+
+// BucketConfig describes a token bucket of the emulated throttling
+type BucketConfig struct {
+	// Name is the name returned in the resource header, it is only used for logging otherwise
+	Name   string `yaml:"name"`
+	Header string `yaml:"header"`
+	// Capacity is the number of tokens of a full bucket, RefillPerSecond the number of tokens added back every second
+	Capacity        float64 `yaml:"capacity"`
+	RefillPerSecond float64 `yaml:"refillPerSecond"`
+	// Operations are the operations drawing a token from the bucket, named after the probes
+	Operations []string `yaml:"operations"`
+}
+
+// bucket is a token bucket, it is not safe for concurrent use
+type bucket struct {
+	BucketConfig
+	tokens  float64
+	updated time.Time
+}
+
+func newBucket(config BucketConfig, now time.Time) *bucket {
+	return &bucket{BucketConfig: config, tokens: config.Capacity, updated: now}
+}
+
+// refill adds the tokens accumulated since the last update
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.Capacity, b.tokens+elapsed*b.RefillPerSecond)
+	}
+	b.updated = now
+}
+
+// remaining returns the number of whole tokens left
+func (b *bucket) remaining() int {
+	return int(math.Floor(b.tokens))
+}
+
+// retryAfter returns how long it takes for a token to be available
+func (b *bucket) retryAfter() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	if b.RefillPerSecond <= 0 {
+		return time.Hour
+	}
+	return time.Duration(math.Ceil((1-b.tokens)/b.RefillPerSecond)) * time.Second
+}
+
+func (b *bucket) appliesTo(operation string) bool {
+	for _, o := range b.Operations {
+		if o == operation || o == "*" {
+			return true
+		}
+	}
+	return false
+}
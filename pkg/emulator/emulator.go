@@ -0,0 +1,364 @@
+// Package emulator serves the Azure Resource Manager endpoints queried by the probes locally,
+// throttling them with a token bucket model, so that the limitometer can be run without a
+// subscription.
+package emulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/common"
+	"gopkg.in/yaml.v2"
+)
+
+// Config describes the emulated resource group and its throttling
+type Config struct {
+	SubscriptionID string   `yaml:"subscriptionId"`
+	ResourceGroup  string   `yaml:"resourceGroup"`
+	Location       string   `yaml:"location"`
+	VMs            []string `yaml:"vms"`
+	// ScaleSets are the VM Scale Sets keyed by name, with their number of instances
+	ScaleSets     map[string]int `yaml:"scaleSets"`
+	LoadBalancers []string       `yaml:"loadBalancers"`
+	Buckets       []BucketConfig `yaml:"buckets"`
+}
+
+var (
+	readOperations  = []string{"getvm", "listvm", "getnic", "listnic", "listlb", "getvmss", "listvmss", "getvmssvm", "listvmssvm", "resource"}
+	writeOperations = []string{"putvm", "putvmssvm"}
+)
+
+// DefaultConfig returns a resource group holding a VM and a VM Scale Set, throttled with
+// budgets close to the ones of Azure Resource Manager
+func DefaultConfig() Config {
+	return Config{
+		SubscriptionID: "00000000-0000-0000-0000-000000000000",
+		ResourceGroup:  "emulated",
+		Location:       "westeurope",
+		VMs:            []string{"node0"},
+		ScaleSets:      map[string]int{"vmss": 3},
+		LoadBalancers:  []string{"kubernetes"},
+		Buckets: []BucketConfig{
+			{"Microsoft.Compute/LowCostGet3Min", HeaderResource, 4000, 4000.0 / 180, []string{"getvm", "getvmssvm"}},
+			{"Microsoft.Compute/LowCostGet30Min", HeaderResource, 32000, 32000.0 / 1800, []string{"getvm", "getvmssvm"}},
+			{"Microsoft.Compute/HighCostGet3Min", HeaderResource, 300, 300.0 / 180, []string{"listvm", "listvmssvm"}},
+			{"Microsoft.Compute/HighCostGet30Min", HeaderResource, 1500, 1500.0 / 1800, []string{"listvm", "listvmssvm"}},
+			{"Microsoft.Compute/PutVM3Min", HeaderResource, 240, 240.0 / 180, []string{"putvm"}},
+			{"Microsoft.Compute/PutVM30Min", HeaderResource, 1200, 1200.0 / 1800, []string{"putvm"}},
+			{"Microsoft.Compute/GetVMScaleSet3Min", HeaderResource, 200, 200.0 / 180, []string{"getvmss"}},
+			{"Microsoft.Compute/GetVMScaleSet30Min", HeaderResource, 1300, 1300.0 / 1800, []string{"getvmss"}},
+			{"Microsoft.Compute/HighCostGetVMScaleSet3Min", HeaderResource, 180, 180.0 / 180, []string{"listvmss"}},
+			{"Microsoft.Compute/HighCostGetVMScaleSet30Min", HeaderResource, 900, 900.0 / 1800, []string{"listvmss"}},
+			{"Microsoft.Compute/VMScaleSetBatchedVMRequests5Min", HeaderResource, 1200, 1200.0 / 300, []string{"putvmssvm"}},
+			{"SubscriptionReads", HeaderSubscriptionReads, 12000, 12000.0 / 3600, readOperations},
+			{"SubscriptionWrites", HeaderSubscriptionWrites, 1200, 1200.0 / 3600, writeOperations},
+			{"SubscriptionGlobalReads", HeaderSubscriptionGlobalReads, 250, 25, readOperations},
+			{"SubscriptionGlobalWrites", HeaderSubscriptionGlobalWrites, 200, 10, writeOperations},
+			{"TenantReads", HeaderTenantReads, 12000, 12000.0 / 3600, readOperations},
+		},
+	}
+}
+
+// LoadConfig returns the default config overridden by the YAML file at path, if any
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+	if path == "" {
+		return config, nil
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read emulator configuration: %v", err)
+	}
+	if err := yaml.UnmarshalStrict(raw, &config); err != nil {
+		return config, fmt.Errorf("failed to parse emulator configuration %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// Emulator is an http.Handler serving the emulated resource group. It also serves the
+// Instance Metadata Service of the first VM, or of the first instance of a VM Scale Set,
+// on /metadata/instance.
+type Emulator struct {
+	config   Config
+	metadata http.Handler
+
+	mu      sync.Mutex
+	buckets []*bucket
+}
+
+// New creates an emulator with full buckets
+func New(config Config) *Emulator {
+	e := &Emulator{config: config}
+
+	now := time.Now()
+	for _, b := range config.Buckets {
+		e.buckets = append(e.buckets, newBucket(b, now))
+	}
+
+	metadata := common.ComputeInstanceMetadata{
+		SubscriptionID:    config.SubscriptionID,
+		ResourceGroupName: config.ResourceGroup,
+		Location:          config.Location,
+		Environment:       "AzurePublicCloud",
+	}
+	if len(config.VMs) > 0 {
+		metadata.Name = config.VMs[0]
+	} else if names := e.scaleSetNames(); len(names) > 0 {
+		metadata.Name = names[0] + "_0"
+		metadata.VMScaleSetName = names[0]
+	}
+	e.metadata = common.InstanceMetadataHandler(metadata)
+	return e
+}
+
+func (e *Emulator) scaleSetNames() []string {
+	names := make([]string, 0, len(e.config.ScaleSets))
+	for name := range e.config.ScaleSets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (e *Emulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/metadata/instance") {
+		e.metadata.ServeHTTP(w, r)
+		return
+	}
+
+	operation, status, body := e.route(r)
+	if operation == "" {
+		writeJSON(w, status, body)
+		return
+	}
+
+	if retryAfter, throttled := e.take(w.Header(), operation); throttled {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+		log.Printf("Throttled %s %s, retry after %s", r.Method, r.URL.Path, retryAfter)
+		writeJSON(w, http.StatusTooManyRequests, armError("TooManyRequests",
+			fmt.Sprintf("The request is being throttled, retry after %d seconds.", int(retryAfter.Seconds()))))
+		return
+	}
+	writeJSON(w, status, body)
+}
+
+// take draws a token from every bucket of the operation and sets the headers of the remaining
+// requests. Nothing is drawn if any bucket is empty, the request then is throttled.
+func (e *Emulator) take(header http.Header, operation string) (retryAfter time.Duration, throttled bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	var applied []*bucket
+	for _, b := range e.buckets {
+		if !b.appliesTo(operation) {
+			continue
+		}
+		b.refill(now)
+		if wait := b.retryAfter(); wait > retryAfter {
+			retryAfter = wait
+		}
+		applied = append(applied, b)
+	}
+
+	throttled = retryAfter > 0
+	var resources []string
+	for _, b := range applied {
+		if !throttled {
+			b.tokens--
+		}
+		if strings.EqualFold(b.Header, HeaderResource) {
+			resources = append(resources, fmt.Sprintf("%s;%d", b.Name, b.remaining()))
+		} else {
+			header.Set(b.Header, strconv.Itoa(b.remaining()))
+		}
+	}
+	if len(resources) > 0 {
+		header.Set(HeaderResource, strings.Join(resources, ","))
+	}
+	return
+}
+
+// route returns the operation of the request, named after the probe making it, and its response.
+// An empty operation is returned for requests outside of the emulated resource group.
+func (e *Emulator) route(r *http.Request) (operation string, status int, body interface{}) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(segments) < 2 || !strings.EqualFold(segments[0], "subscriptions") || !strings.EqualFold(segments[1], e.config.SubscriptionID) {
+		return "", http.StatusNotFound, armError("SubscriptionNotFound", fmt.Sprintf("The subscription of %s could not be found.", r.URL.Path))
+	}
+	if len(segments) < 4 || !strings.EqualFold(segments[2], "resourceGroups") {
+		if r.Method == http.MethodGet {
+			return "resource", http.StatusOK, list(nil)
+		}
+		return "", http.StatusMethodNotAllowed, armError("MethodNotAllowed", fmt.Sprintf("%s %s is not emulated.", r.Method, r.URL.Path))
+	}
+	if !strings.EqualFold(segments[3], e.config.ResourceGroup) {
+		return "", http.StatusNotFound, armError("ResourceGroupNotFound", fmt.Sprintf("Resource group '%s' could not be found.", segments[3]))
+	}
+	if len(segments) < 7 || !strings.EqualFold(segments[4], "providers") {
+		return "resource", http.StatusOK, list(nil)
+	}
+
+	resourceType := segments[5] + "/" + segments[6]
+	names := segments[7:]
+	get := r.Method == http.MethodGet
+	put := r.Method == http.MethodPut
+
+	switch {
+	case strings.EqualFold(resourceType, "Microsoft.Compute/virtualMachines") && len(names) == 0 && get:
+		var values []interface{}
+		for _, name := range e.config.VMs {
+			values = append(values, e.vm(name))
+		}
+		return "listvm", http.StatusOK, list(values)
+	case strings.EqualFold(resourceType, "Microsoft.Compute/virtualMachines") && len(names) == 1 && (get || put):
+		operation = "getvm"
+		if put {
+			operation = "putvm"
+		}
+		if !contains(e.config.VMs, names[0]) {
+			return operation, http.StatusNotFound, notFound("Microsoft.Compute/virtualMachines", names[0])
+		}
+		return operation, http.StatusOK, e.vm(names[0])
+	case strings.EqualFold(resourceType, "Microsoft.Network/networkInterfaces") && len(names) == 0 && get:
+		var values []interface{}
+		for _, name := range e.config.VMs {
+			values = append(values, e.nic(name+"-nic", name))
+		}
+		return "listnic", http.StatusOK, list(values)
+	case strings.EqualFold(resourceType, "Microsoft.Network/networkInterfaces") && len(names) == 1 && get:
+		vm := strings.TrimSuffix(names[0], "-nic")
+		if vm == names[0] || !contains(e.config.VMs, vm) {
+			return "getnic", http.StatusNotFound, notFound("Microsoft.Network/networkInterfaces", names[0])
+		}
+		return "getnic", http.StatusOK, e.nic(names[0], vm)
+	case strings.EqualFold(resourceType, "Microsoft.Network/loadBalancers") && len(names) == 0 && get:
+		var values []interface{}
+		for _, name := range e.config.LoadBalancers {
+			values = append(values, e.resource("Microsoft.Network/loadBalancers", name, nil))
+		}
+		return "listlb", http.StatusOK, list(values)
+	case strings.EqualFold(resourceType, "Microsoft.Compute/virtualMachineScaleSets") && len(names) == 0 && get:
+		var values []interface{}
+		for _, name := range e.scaleSetNames() {
+			values = append(values, e.vmss(name))
+		}
+		return "listvmss", http.StatusOK, list(values)
+	case strings.EqualFold(resourceType, "Microsoft.Compute/virtualMachineScaleSets") && len(names) == 1 && get:
+		if _, exists := e.config.ScaleSets[names[0]]; !exists {
+			return "getvmss", http.StatusNotFound, notFound("Microsoft.Compute/virtualMachineScaleSets", names[0])
+		}
+		return "getvmss", http.StatusOK, e.vmss(names[0])
+	case strings.EqualFold(resourceType, "Microsoft.Compute/virtualMachineScaleSets") && len(names) == 2 && strings.EqualFold(names[1], "virtualMachines") && get:
+		capacity, exists := e.config.ScaleSets[names[0]]
+		if !exists {
+			return "listvmssvm", http.StatusNotFound, notFound("Microsoft.Compute/virtualMachineScaleSets", names[0])
+		}
+		var values []interface{}
+		for id := 0; id < capacity; id++ {
+			values = append(values, e.vmssVM(names[0], strconv.Itoa(id)))
+		}
+		return "listvmssvm", http.StatusOK, list(values)
+	case strings.EqualFold(resourceType, "Microsoft.Compute/virtualMachineScaleSets") && len(names) == 3 && strings.EqualFold(names[1], "virtualMachines") && (get || put):
+		operation = "getvmssvm"
+		if put {
+			operation = "putvmssvm"
+		}
+		capacity, exists := e.config.ScaleSets[names[0]]
+		if id, err := strconv.Atoi(names[2]); !exists || err != nil || id < 0 || id >= capacity {
+			return operation, http.StatusNotFound, notFound("Microsoft.Compute/virtualMachineScaleSets/virtualMachines", names[0]+"/"+names[2])
+		}
+		return operation, http.StatusOK, e.vmssVM(names[0], names[2])
+	case get:
+		return "resource", http.StatusOK, list(nil)
+	}
+	return "", http.StatusMethodNotAllowed, armError("MethodNotAllowed", fmt.Sprintf("%s %s is not emulated.", r.Method, r.URL.Path))
+}
+
+func (e *Emulator) id(resourceType string, name string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s/%s", e.config.SubscriptionID, e.config.ResourceGroup, resourceType, name)
+}
+
+func (e *Emulator) resource(resourceType string, name string, properties map[string]interface{}) map[string]interface{} {
+	if properties == nil {
+		properties = map[string]interface{}{}
+	}
+	properties["provisioningState"] = "Succeeded"
+	return map[string]interface{}{
+		"id":         e.id(resourceType, name),
+		"name":       name,
+		"type":       resourceType,
+		"location":   e.config.Location,
+		"properties": properties,
+	}
+}
+
+func (e *Emulator) vm(name string) map[string]interface{} {
+	return e.resource("Microsoft.Compute/virtualMachines", name, map[string]interface{}{
+		"networkProfile": map[string]interface{}{
+			"networkInterfaces": []interface{}{map[string]interface{}{
+				"id":         e.id("Microsoft.Network/networkInterfaces", name+"-nic"),
+				"properties": map[string]interface{}{"primary": true},
+			}},
+		},
+	})
+}
+
+func (e *Emulator) nic(name string, vm string) map[string]interface{} {
+	return e.resource("Microsoft.Network/networkInterfaces", name, map[string]interface{}{
+		"primary":        true,
+		"virtualMachine": map[string]interface{}{"id": e.id("Microsoft.Compute/virtualMachines", vm)},
+	})
+}
+
+func (e *Emulator) vmss(name string) map[string]interface{} {
+	vmss := e.resource("Microsoft.Compute/virtualMachineScaleSets", name, nil)
+	vmss["sku"] = map[string]interface{}{"name": "Standard_DS2_v2", "capacity": e.config.ScaleSets[name]}
+	return vmss
+}
+
+func (e *Emulator) vmssVM(vmss string, id string) map[string]interface{} {
+	vm := e.resource("Microsoft.Compute/virtualMachineScaleSets/virtualMachines", vmss+"/virtualMachines/"+id, nil)
+	vm["name"] = vmss + "_" + id
+	vm["instanceId"] = id
+	return vm
+}
+
+func list(values []interface{}) map[string]interface{} {
+	if values == nil {
+		values = []interface{}{}
+	}
+	return map[string]interface{}{"value": values}
+}
+
+func armError(code string, message string) map[string]interface{} {
+	return map[string]interface{}{"error": map[string]interface{}{"code": code, "message": message}}
+}
+
+func notFound(resourceType string, name string) map[string]interface{} {
+	return armError("ResourceNotFound", fmt.Sprintf("The Resource '%s/%s' was not found.", resourceType, name))
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package outputs
+
+// AlertLevel is the severity of an alert, ordered from the least to the most severe
+type AlertLevel int
+
+const (
+	// AlertOK denotes a bucket breaching none of the thresholds of a rule
+	AlertOK AlertLevel = iota
+	// AlertWarning denotes a bucket breaching the warning threshold of a rule
+	AlertWarning
+	// AlertCritical denotes a bucket breaching the critical threshold of a rule
+	AlertCritical
+)
+
+func (l AlertLevel) String() string {
+	switch l {
+	case AlertWarning:
+		return "warning"
+	case AlertCritical:
+		return "critical"
+	default:
+		return "ok"
+	}
+}
+
+// Alert This struct contains the state of an alert rule for one of the buckets it applies to
+type Alert struct {
+	Rule   string
+	Bucket Bucket
+	Level  AlertLevel
+}
+
+// alertLabelNames are the labels of the alert metrics, see Alert.labelValues
+var alertLabelNames = append([]string{"rule"}, bucketLabelNames...)
+
+// labelValues Returns the values of the alertLabelNames labels
+func (a Alert) labelValues() []string {
+	return append([]string{a.Rule}, a.Bucket.labelValues()...)
+}
@@ -0,0 +1,155 @@
+package outputs
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scope is the level a bucket counts the requests at
+type Scope string
+
+const (
+	// ScopeResource denotes the buckets of a resource provider, e.g. "Microsoft.Compute/HighCostGet3Min"
+	ScopeResource Scope = "resource"
+	// ScopeSubscription denotes the buckets shared by every request of a subscription, e.g. "SubIDReads"
+	ScopeSubscription Scope = "subscription"
+	// ScopeTenant denotes the buckets shared by every request of a tenant, e.g. "TenantReads"
+	ScopeTenant Scope = "tenant"
+)
+
+// subscriptionWindow is the window of the subscription and tenant level limits, documented as requests per hour
+const subscriptionWindow = time.Hour
+
+var bucketWindowFormat = regexp.MustCompile(`^(.*?)(\d+)(Sec|Min|Hour|Day)$`)
+
+var windowUnits = map[string]time.Duration{
+	"Sec":  time.Second,
+	"Min":  time.Minute,
+	"Hour": time.Hour,
+	"Day":  24 * time.Hour,
+}
+
+// Bucket This struct describes a bucket of the ARM throttling, as decomposed from its name
+type Bucket struct {
+	// Name is the name the remaining requests are reported under, e.g. "Microsoft.Compute/HighCostGet3Min"
+	Name string
+	// Provider is the resource provider of the bucket, "arm" for the subscription and tenant level buckets
+	Provider string
+	// Operation is the class of operations counted by the bucket, e.g. "HighCostGet" or "Reads"
+	Operation string
+	// Window is the duration the requests are counted over, 0 if unknown
+	Window time.Duration
+	Scope  Scope
+	Region string
+}
+
+// ParseBucket Decomposes a bucket name such as "Microsoft.Compute/HighCostGet3Min" into its provider,
+// operation class and window, here "Microsoft.Compute", "HighCostGet" and 3 minutes. Subscription and
+// tenant level buckets such as "SubIDGlobalReads" have "arm" as provider, and the operation stripped
+// from the scope and region, here "Reads".
+func ParseBucket(name string) Bucket {
+	b := Bucket{Name: name, Region: Region(name)}
+
+	if parts := strings.SplitN(name, "/", 2); len(parts) == 2 {
+		b.Scope = ScopeResource
+		b.Provider, b.Operation = parts[0], parts[1]
+		if matches := bucketWindowFormat.FindStringSubmatch(b.Operation); matches != nil {
+			count, _ := strconv.Atoi(matches[2])
+			b.Operation = matches[1]
+			b.Window = time.Duration(count) * windowUnits[matches[3]]
+		}
+		return b
+	}
+
+	b.Provider, b.Operation, b.Scope = "arm", name, ScopeResource
+	for prefix, scope := range map[string]Scope{"SubID": ScopeSubscription, "Tenant": ScopeTenant} {
+		if strings.HasPrefix(name, prefix) {
+			b.Scope = scope
+			b.Operation = strings.TrimPrefix(strings.TrimPrefix(name, prefix), "Global")
+		}
+	}
+	// The global buckets are token buckets refilled continuously rather than hourly limits
+	if b.Scope != ScopeResource && b.Region == RegionRegional {
+		b.Window = subscriptionWindow
+	}
+	return b
+}
+
+// WindowLabel Returns the window in its short form, e.g. "3m", "30m" or "1h", empty if unknown
+func (b Bucket) WindowLabel() string {
+	switch {
+	case b.Window <= 0:
+		return ""
+	case b.Window%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", b.Window/(24*time.Hour))
+	case b.Window%time.Hour == 0:
+		return fmt.Sprintf("%dh", b.Window/time.Hour)
+	case b.Window%time.Minute == 0:
+		return fmt.Sprintf("%dm", b.Window/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", b.Window/time.Second)
+	}
+}
+
+// Labels Returns the fields of the bucket keyed by their label name, the window is left out if unknown
+func (b Bucket) Labels() map[string]string {
+	labels := map[string]string{
+		"bucket":    b.Name,
+		"provider":  b.Provider,
+		"operation": b.Operation,
+		"scope":     string(b.Scope),
+		"region":    b.Region,
+	}
+	if window := b.WindowLabel(); window != "" {
+		labels["window"] = window
+	}
+	return labels
+}
+
+// bucketLabelNames are the labels of the metrics of a bucket, see Bucket.labelValues
+var bucketLabelNames = []string{"type", "provider", "operation", "window", "scope", "region"}
+
+// labelValues Returns the values of the bucketLabelNames labels
+func (b Bucket) labelValues() []string {
+	return []string{b.Name, b.Provider, b.Operation, b.WindowLabel(), string(b.Scope), b.Region}
+}
+
+// Sample This struct contains the requests remaining in a bucket at the time of a poll
+type Sample struct {
+	Bucket    Bucket
+	Remaining int
+	// Time is when the value was collected, before the poll if the value is stale
+	Time  time.Time
+	Stale bool
+	// Forecast is nil if the bucket was not sampled often enough to be forecast
+	Forecast *Forecast
+	// Counters is nil if the poll was not recorded in a History
+	Counters *Counters
+}
+
+// Samples Returns the values of the poll as samples, sorted by bucket name
+func (p Poll) Samples() []Sample {
+	samples := make([]Sample, 0, len(p.Values))
+	for name, remaining := range p.Values {
+		sample := Sample{Bucket: ParseBucket(name), Remaining: remaining, Time: p.Time}
+		if collected, stale := p.Stale[name]; stale {
+			sample.Time = collected
+			sample.Stale = true
+		}
+		if forecast, ok := p.Forecasts[name]; ok {
+			sample.Forecast = &forecast
+		}
+		if counters, ok := p.Counters[name]; ok {
+			sample.Counters = &counters
+		}
+		samples = append(samples, sample)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Bucket.Name < samples[j].Bucket.Name
+	})
+	return samples
+}
@@ -0,0 +1,117 @@
+package outputs
+
+import (
+	"time"
+)
+
+// maxLookback bounds the samples a forecast is made from for the buckets whose window is unknown
+const maxLookback = 30 * time.Minute
+
+// Forecast This struct contains the projected exhaustion of a bucket at its current consumption rate
+type Forecast struct {
+	// Rate is the number of requests consumed per second over the samples since the last refill
+	Rate float64
+	// Exhausts reports whether the bucket is projected to run out of requests, TimeToExhaustion
+	// being the time left until then
+	Exhausts         bool
+	TimeToExhaustion time.Duration
+}
+
+// Counters This struct contains the totals derived from the successive values of a bucket
+type Counters struct {
+	// Consumed is the number of requests consumed, summed from the decreases between successive polls
+	Consumed int
+	// Resets is the number of times requests came back to the bucket, as its window slid or reset
+	Resets int
+}
+
+type timedValue struct {
+	time      time.Time
+	remaining int
+}
+
+// History This struct keeps the samples of successive polls to derive the trend of every bucket
+// from, it is not safe for concurrent use
+type History struct {
+	samples  map[string][]timedValue
+	counters map[string]Counters
+}
+
+// NewHistory Creates an empty history
+func NewHistory() *History {
+	return &History{samples: map[string][]timedValue{}, counters: map[string]Counters{}}
+}
+
+// Update Records the values of the poll and sets the counters of every bucket of the poll, plus the
+// forecast of those sampled at least twice since their last refill. Stale values are not recorded,
+// their buckets keep the counters they had and are not forecast.
+func (h *History) Update(poll *Poll) {
+	poll.Forecasts = map[string]Forecast{}
+	poll.Counters = map[string]Counters{}
+	for _, sample := range poll.Samples() {
+		name := sample.Bucket.Name
+		samples := h.samples[name]
+		counters, known := h.counters[name]
+		if sample.Stale {
+			if known {
+				poll.Counters[name] = counters
+			}
+			continue
+		}
+
+		// Requests only come back when the window slides or resets, the consumption is measured from there.
+		// The requests consumed in the interval of a reset cannot be told and are not counted.
+		if n := len(samples); n > 0 {
+			if previous := samples[n-1].remaining; sample.Remaining > previous {
+				counters.Resets++
+				samples = nil
+			} else {
+				counters.Consumed += previous - sample.Remaining
+			}
+		}
+		h.counters[name] = counters
+		poll.Counters[name] = counters
+
+		samples = append(samples, timedValue{poll.Time, sample.Remaining})
+
+		lookback := sample.Bucket.Window
+		if lookback <= 0 {
+			lookback = maxLookback
+		}
+		for len(samples) > 2 && poll.Time.Sub(samples[0].time) > lookback {
+			samples = samples[1:]
+		}
+		h.samples[name] = samples
+
+		if forecast, ok := forecast(sample.Bucket, samples); ok {
+			poll.Forecasts[name] = forecast
+		}
+	}
+}
+
+// forecast projects the exhaustion of the bucket from its samples, oldest first. Requests consumed
+// longer than a window ago are given back, so a bucket running out later than a window from now is
+// not projected to run out at all.
+func forecast(bucket Bucket, samples []timedValue) (Forecast, bool) {
+	if len(samples) < 2 {
+		return Forecast{}, false
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	elapsed := last.time.Sub(first.time).Seconds()
+	if elapsed <= 0 {
+		return Forecast{}, false
+	}
+
+	f := Forecast{Rate: float64(first.remaining-last.remaining) / elapsed}
+	switch {
+	case last.remaining <= 0:
+		f.Exhausts = true
+	case f.Rate > 0:
+		f.TimeToExhaustion = time.Duration(float64(last.remaining) / f.Rate * float64(time.Second))
+		f.Exhausts = bucket.Window <= 0 || f.TimeToExhaustion <= bucket.Window
+	}
+	if !f.Exhausts {
+		f.TimeToExhaustion = 0
+	}
+	return f, true
+}
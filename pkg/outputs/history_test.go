@@ -0,0 +1,163 @@
+package outputs
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+type historyStep struct {
+	at        time.Duration
+	remaining int
+	stale     bool
+}
+
+func TestHistoryUpdate(t *testing.T) {
+	start := time.Date(2020, 6, 15, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		bucket   string
+		steps    []historyStep
+		forecast *Forecast
+		counters Counters
+	}{
+		{
+			name:   "single sample",
+			bucket: "Microsoft.Compute/HighCostGet3Min",
+			steps:  []historyStep{{0, 100, false}},
+		},
+		{
+			name:     "zero elapsed time",
+			bucket:   "Microsoft.Compute/HighCostGet3Min",
+			steps:    []historyStep{{0, 100, false}, {0, 90, false}},
+			counters: Counters{Consumed: 10},
+		},
+		{
+			name:     "exhaustion within the window",
+			bucket:   "Microsoft.Compute/HighCostGet3Min",
+			steps:    []historyStep{{0, 100, false}, {time.Minute, 40, false}},
+			forecast: &Forecast{Rate: 1, Exhausts: true, TimeToExhaustion: 40 * time.Second},
+			counters: Counters{Consumed: 60},
+		},
+		{
+			name:     "exhaustion beyond the window",
+			bucket:   "Microsoft.Compute/HighCostGet3Min",
+			steps:    []historyStep{{0, 100, false}, {time.Minute, 90, false}},
+			forecast: &Forecast{Rate: 10.0 / 60},
+			counters: Counters{Consumed: 10},
+		},
+		{
+			name:     "exhausted",
+			bucket:   "Microsoft.Compute/HighCostGet3Min",
+			steps:    []historyStep{{0, 10, false}, {time.Minute, 0, false}},
+			forecast: &Forecast{Rate: 10.0 / 60, Exhausts: true},
+			counters: Counters{Consumed: 10},
+		},
+		{
+			name:     "no consumption",
+			bucket:   "Microsoft.Compute/HighCostGet3Min",
+			steps:    []historyStep{{0, 100, false}, {time.Minute, 100, false}},
+			forecast: &Forecast{},
+		},
+		{
+			name:     "no window",
+			bucket:   "Microsoft.Network/Writes",
+			steps:    []historyStep{{0, 1000, false}, {time.Minute, 990, false}},
+			forecast: &Forecast{Rate: 10.0 / 60, Exhausts: true, TimeToExhaustion: 5940 * time.Second},
+			counters: Counters{Consumed: 10},
+		},
+		{
+			name:   "no window looks back at most maxLookback",
+			bucket: "Microsoft.Network/Writes",
+			steps: []historyStep{
+				{0, 1000, false},
+				{40 * time.Minute, 990, false},
+				{41 * time.Minute, 980, false},
+			},
+			forecast: &Forecast{Rate: 10.0 / 60, Exhausts: true, TimeToExhaustion: 5880 * time.Second},
+			counters: Counters{Consumed: 20},
+		},
+		{
+			name:     "reset",
+			bucket:   "Microsoft.Compute/HighCostGet3Min",
+			steps:    []historyStep{{0, 100, false}, {time.Minute, 90, false}, {2 * time.Minute, 95, false}},
+			counters: Counters{Consumed: 10, Resets: 1},
+		},
+		{
+			name:   "consumption since the reset",
+			bucket: "Microsoft.Compute/HighCostGet3Min",
+			steps: []historyStep{
+				{0, 100, false},
+				{time.Minute, 90, false},
+				{2 * time.Minute, 95, false},
+				{3 * time.Minute, 85, false},
+			},
+			forecast: &Forecast{Rate: 10.0 / 60},
+			counters: Counters{Consumed: 20, Resets: 1},
+		},
+		{
+			name:     "stale values are not recorded",
+			bucket:   "Microsoft.Compute/HighCostGet3Min",
+			steps:    []historyStep{{0, 100, false}, {30 * time.Second, 0, true}, {time.Minute, 90, false}},
+			forecast: &Forecast{Rate: 10.0 / 60},
+			counters: Counters{Consumed: 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHistory()
+			var poll Poll
+			for _, step := range tt.steps {
+				poll = Poll{Values: map[string]int{tt.bucket: step.remaining}, Time: start.Add(step.at)}
+				if step.stale {
+					poll.Stale = map[string]time.Time{tt.bucket: start}
+				}
+				h.Update(&poll)
+			}
+
+			if counters := poll.Counters[tt.bucket]; counters != tt.counters {
+				t.Errorf("counters = %+v, want %+v", counters, tt.counters)
+			}
+			forecast, ok := poll.Forecasts[tt.bucket]
+			switch {
+			case tt.forecast == nil && ok:
+				t.Errorf("forecast = %+v, want none", forecast)
+			case tt.forecast != nil && !ok:
+				t.Errorf("no forecast, want %+v", *tt.forecast)
+			case tt.forecast != nil:
+				if math.Abs(forecast.Rate-tt.forecast.Rate) > 1e-9 ||
+					forecast.Exhausts != tt.forecast.Exhausts ||
+					(forecast.TimeToExhaustion-tt.forecast.TimeToExhaustion).Round(time.Millisecond) != 0 {
+					t.Errorf("forecast = %+v, want %+v", forecast, *tt.forecast)
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryUpdateStaleKeepsCounters(t *testing.T) {
+	start := time.Date(2020, 6, 15, 10, 0, 0, 0, time.UTC)
+	bucket := "Microsoft.Compute/HighCostGet3Min"
+	h := NewHistory()
+
+	poll := Poll{Values: map[string]int{bucket: 100}, Time: start}
+	h.Update(&poll)
+	poll = Poll{Values: map[string]int{bucket: 90}, Time: start.Add(time.Minute)}
+	h.Update(&poll)
+
+	poll = Poll{
+		Values: map[string]int{bucket: 0},
+		Stale:  map[string]time.Time{bucket: start.Add(time.Minute)},
+		Time:   start.Add(2 * time.Minute),
+	}
+	h.Update(&poll)
+
+	if counters, want := poll.Counters[bucket], (Counters{Consumed: 10}); counters != want {
+		t.Errorf("counters = %+v, want %+v", counters, want)
+	}
+	if forecast, ok := poll.Forecasts[bucket]; ok {
+		t.Errorf("forecast = %+v, want none for a stale value", forecast)
+	}
+}
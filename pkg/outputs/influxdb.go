@@ -1,68 +1,401 @@
 package outputs
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/influxdata/influxdb/client/v2"
 )
 
+func init() {
+	RegisterSink("influxdb", func() (Sink, error) {
+		return sinkFunc{"influxdb", WriteOutputInflux}, nil
+	})
+	RegisterSink("influxdb2", func() (Sink, error) {
+		return sinkFunc{"influxdb2", WriteOutputInflux2}, nil
+	})
+}
+
 // InfluxDBServer This struct contains the information necessary to connect to a InfluxDB server
 // such as host, port and database
 type InfluxDBServer struct {
-	Host     string
-	Port     string
-	Database string
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	Database string `yaml:"database"`
+
+	// Scheme is either http or https
+	Scheme string `yaml:"scheme"`
+	// CACert is the path of a PEM encoded CA certificate used to verify the server
+	CACert             string `yaml:"caCert"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+
+	// Schema is either legacy, writing one measurement per bucket, or tagged, writing every
+	// bucket to Measurement with the bucket name decomposed in tags
+	Schema      string `yaml:"schema"`
+	Measurement string `yaml:"measurement"`
+
+	// Username, Password and RetentionPolicy are only used by InfluxDB 1.x
+	Username        string `yaml:"username"`
+	Password        string `yaml:"password"`
+	RetentionPolicy string `yaml:"retentionPolicy"`
+
+	// Token, Org and Bucket are only used by InfluxDB 2.x
+	Token  string `yaml:"token"`
+	Org    string `yaml:"org"`
+	Bucket string `yaml:"bucket"`
 }
 
-// GetInfluxdbConfig Generates a server config from environment variables
+var influxdbServer *InfluxDBServer
+
+// SetInfluxdbConfig Sets the server config used instead of the one generated from environment variables
+func SetInfluxdbConfig(server InfluxDBServer) {
+	influxdbServer = &server
+}
+
+// GetInfluxdbConfig Returns the server config set through SetInfluxdbConfig, or generates one from
+// environment variables if none was set
 func GetInfluxdbConfig() InfluxDBServer {
-	server := InfluxDBServer{
-		Host:     os.Getenv("INFLUXDB_HOST"),
-		Port:     os.Getenv("INFLUXDB_PORT"),
-		Database: os.Getenv("INFLUXDB_DATABASE"),
+	if influxdbServer != nil {
+		return *influxdbServer
+	}
+	return InfluxDBServer{}.WithEnvironment()
+}
+
+// WithEnvironment Returns the server config overridden by the environment variables that are set,
+// with defaults for the fields left empty
+func (s InfluxDBServer) WithEnvironment() InfluxDBServer {
+	for _, setting := range []struct {
+		variable string
+		field    *string
+	}{
+		{"INFLUXDB_HOST", &s.Host},
+		{"INFLUXDB_PORT", &s.Port},
+		{"INFLUXDB_DATABASE", &s.Database},
+		{"INFLUXDB_SCHEME", &s.Scheme},
+		{"INFLUXDB_SCHEMA", &s.Schema},
+		{"INFLUXDB_MEASUREMENT", &s.Measurement},
+		{"INFLUXDB_CA_CERT", &s.CACert},
+		{"INFLUXDB_USERNAME", &s.Username},
+		{"INFLUXDB_PASSWORD", &s.Password},
+		{"INFLUXDB_RETENTION_POLICY", &s.RetentionPolicy},
+		{"INFLUXDB_TOKEN", &s.Token},
+		{"INFLUXDB_ORG", &s.Org},
+		{"INFLUXDB_BUCKET", &s.Bucket},
+	} {
+		if value := os.Getenv(setting.variable); value != "" {
+			*setting.field = value
+		}
+	}
+	if skip, err := strconv.ParseBool(os.Getenv("INFLUXDB_INSECURE_SKIP_VERIFY")); err == nil {
+		s.InsecureSkipVerify = skip
+	}
+
+	if s.Scheme == "" {
+		s.Scheme = "http"
+	}
+	if s.Schema == "" {
+		s.Schema = "legacy"
+	}
+	if s.Measurement == "" {
+		s.Measurement = "azure_arm_ratelimit"
+	}
+	return s
+}
+
+// Validate reports the first setting preventing a write to InfluxDB of the given major version
+func (s InfluxDBServer) Validate(version int) error {
+	if s.Host == "" {
+		return fmt.Errorf("host is required")
+	}
+	if _, err := strconv.ParseUint(s.Port, 10, 16); err != nil {
+		return fmt.Errorf("invalid port %q", s.Port)
+	}
+	if s.Scheme != "http" && s.Scheme != "https" {
+		return fmt.Errorf("unknown scheme %q, supported values are: [http|https]", s.Scheme)
+	}
+	if s.Schema != "legacy" && s.Schema != "tagged" {
+		return fmt.Errorf("unknown schema %q, supported values are: [legacy|tagged]", s.Schema)
+	}
+	if _, err := url.Parse(s.Addr()); err != nil {
+		return fmt.Errorf("invalid address: %v", err)
+	}
+	if _, err := s.TLSConfig(); err != nil {
+		return err
+	}
+
+	switch version {
+	case 1:
+		if s.Database == "" {
+			return fmt.Errorf("database is required")
+		}
+	case 2:
+		if s.Token == "" || s.Org == "" || s.Bucket == "" {
+			return fmt.Errorf("token, org and bucket are required")
+		}
+	default:
+		return fmt.Errorf("unknown InfluxDB version %d", version)
+	}
+	return nil
+}
+
+// Addr returns the base URL of the server
+func (s InfluxDBServer) Addr() string {
+	return fmt.Sprintf("%s://%s:%s", s.Scheme, s.Host, s.Port)
+}
+
+// TLSConfig returns the TLS configuration trusting the configured CA certificate
+func (s InfluxDBServer) TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: s.InsecureSkipVerify}
+	if s.CACert == "" {
+		return config, nil
+	}
+
+	pem, err := ioutil.ReadFile(s.CACert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", s.CACert)
+	}
+	return config, nil
+}
+
+// influxPoints Creates the points of a poll, one per bucket plus one per forecast bucket in the forecast
+// measurement, one per probe in the probeStatus measurement, one per throttled request in the throttle measurement
+// and one per alert in the alert measurement
+func influxPoints(s InfluxDBServer, poll Poll, fieldName string) ([]*client.Point, error) {
+	var points []*client.Point
+
+	if s.Schema != "legacy" && s.Schema != "tagged" {
+		return nil, fmt.Errorf("unknown schema %q, supported values are: [legacy|tagged]", s.Schema)
+	}
+
+	for _, sample := range poll.Samples() {
+		measurement := sample.Bucket.Name
+		tags := sample.Bucket.Labels()
+		delete(tags, "bucket")
+		if s.Schema == "tagged" {
+			measurement = s.Measurement
+			tags = taggedLabels(poll)
+			for name, value := range sample.Bucket.Labels() {
+				tags[name] = value
+			}
+		}
+		fields := map[string]interface{}{
+			fieldName: sample.Remaining,
+		}
+		if sample.Counters != nil {
+			fields["requestsConsumed"] = sample.Counters.Consumed
+			fields["resets"] = sample.Counters.Resets
+		}
+
+		pt, err := client.NewPoint(measurement, tags, fields, sample.Time)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, pt)
+
+		if sample.Forecast != nil {
+			pt, err := forecastPoint(s, poll, sample)
+			if err != nil {
+				return nil, err
+			}
+			points = append(points, pt)
+		}
+	}
+
+	for _, status := range poll.Statuses {
+		tags := map[string]string{}
+		if s.Schema == "tagged" {
+			tags = taggedLabels(poll)
+		}
+		tags["probe"] = status.Probe
+		fields := map[string]interface{}{
+			"statusCode": status.StatusCode,
+			"success":    status.Succeeded(),
+			"error":      status.Error,
+		}
+
+		pt, err := client.NewPoint("probeStatus", tags, fields, poll.Time)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, pt)
+	}
+
+	for _, throttle := range poll.Throttles {
+		tags := map[string]string{}
+		if s.Schema == "tagged" {
+			tags = taggedLabels(poll)
+		}
+		tags["probe"] = throttle.Probe
+		if throttle.Bucket != "" {
+			tags["bucket"] = throttle.Bucket
+		}
+		fields := map[string]interface{}{
+			"count":             1,
+			"retryAfterSeconds": throttle.RetryAfter.Seconds(),
+		}
+
+		pt, err := client.NewPoint("throttle", tags, fields, poll.Time)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, pt)
+	}
+
+	for _, alert := range poll.Alerts {
+		tags := map[string]string{}
+		if s.Schema == "tagged" {
+			tags = taggedLabels(poll)
+		}
+		for name, value := range alert.Bucket.Labels() {
+			tags[name] = value
+		}
+		tags["rule"] = alert.Rule
+		fields := map[string]interface{}{
+			"level": int(alert.Level),
+			"state": alert.Level.String(),
+		}
+
+		pt, err := client.NewPoint("alert", tags, fields, poll.Time)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, pt)
+	}
+
+	return points, nil
+}
+
+// taggedLabels Returns the tags of the tagged schema describing where the poll was made, which are
+// all there whether or not they were selected for the outputs
+func taggedLabels(poll Poll) map[string]string {
+	labels := poll.Target.Labels()
+	for name, value := range poll.Metadata.Labels() {
+		labels[name] = value
+	}
+	return labels
+}
+
+// forecastPoint Creates the point of the forecast of a bucket, the time to exhaustion is left out if the
+// bucket is not projected to run out
+func forecastPoint(s InfluxDBServer, poll Poll, sample Sample) (*client.Point, error) {
+	tags := map[string]string{}
+	if s.Schema == "tagged" {
+		tags = taggedLabels(poll)
+	}
+	for name, value := range sample.Bucket.Labels() {
+		tags[name] = value
+	}
+	fields := map[string]interface{}{
+		"consumptionRate": sample.Forecast.Rate,
+		"exhausts":        sample.Forecast.Exhausts,
+	}
+	if sample.Forecast.Exhausts {
+		fields["timeToExhaustionSeconds"] = sample.Forecast.TimeToExhaustion.Seconds()
 	}
-	return server
+	return client.NewPoint("forecast", tags, fields, poll.Time)
 }
 
-// WriteOutputInflux Creates a Batch of points given a map
-func WriteOutputInflux(values map[string]int, fieldName string) {
+// WriteOutputInflux Writes a Batch of points of the poll to InfluxDB 1.x
+func WriteOutputInflux(poll Poll) error {
 	s := GetInfluxdbConfig()
 
+	tlsConfig, err := s.TLSConfig()
+	if err != nil {
+		return err
+	}
+
 	c, err := client.NewHTTPClient(client.HTTPConfig{
-		Addr: fmt.Sprintf("http://%s:%s", s.Host, s.Port),
+		Addr:      s.Addr(),
+		Username:  s.Username,
+		Password:  s.Password,
+		TLSConfig: tlsConfig,
 	})
 	if err != nil {
-		log.Fatalf("failed to create new HTTP client: %v", err)
+		return fmt.Errorf("failed to create new HTTP client: %v", err)
 	}
 	defer c.Close()
 
 	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
-		Database:  s.Database,
-		Precision: "s",
+		Database:        s.Database,
+		RetentionPolicy: s.RetentionPolicy,
+		Precision:       "s",
 	})
 	if err != nil {
-		log.Fatalf("failed to create new batch points: %v", err)
+		return fmt.Errorf("failed to create new batch points: %v", err)
 	}
 
-	for k, v := range values {
-		tags := make(map[string]string)
-		fields := map[string]interface{}{
-			fieldName: v,
-		}
-
-		pt, err := client.NewPoint(k, tags, fields, time.Now())
-		if err != nil {
-			log.Fatal(err)
-		}
-		bp.AddPoint(pt)
+	points, err := influxPoints(s, poll, "requestRemaining")
+	if err != nil {
+		return err
 	}
+	bp.AddPoints(points)
 
 	if err := c.Write(bp); err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	log.Println("Successfully wrote to InfluxDB")
+	return nil
+}
+
+// WriteOutputInflux2 Writes the points of the poll to the write API of InfluxDB 2.x
+func WriteOutputInflux2(poll Poll) error {
+	s := GetInfluxdbConfig()
+
+	tlsConfig, err := s.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	points, err := influxPoints(s, poll, "requestRemaining")
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	for _, pt := range points {
+		body.WriteString(pt.PrecisionString("s"))
+		body.WriteByte('\n')
+	}
+
+	query := url.Values{}
+	query.Set("org", s.Org)
+	query.Set("bucket", s.Bucket)
+	query.Set("precision", "s")
+	req, err := http.NewRequest(http.MethodPost, s.Addr()+"/api/v2/write?"+query.Encode(), &body)
+	if err != nil {
+		return fmt.Errorf("failed to create write request: %v", err)
+	}
+	req.Header.Set("Authorization", "Token "+s.Token)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	c := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("InfluxDB returned StatusCode %d: %s", resp.StatusCode, bytes.TrimSpace(message))
 	}
 
 	log.Println("Successfully wrote to InfluxDB")
+	return nil
 }
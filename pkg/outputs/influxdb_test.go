@@ -0,0 +1,57 @@
+package outputs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInfluxPointsTags(t *testing.T) {
+	poll := Poll{
+		Values:   map[string]int{"Microsoft.Compute/HighCostGet3Min": 120},
+		Metadata: Metadata{Cluster: "aks"},
+		Target:   Metadata{Subscription: "sub", ResourceGroup: "rg", Node: "node0"},
+		Time:     time.Date(2020, 6, 15, 10, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		schema      string
+		measurement string
+		tags        map[string]string
+	}{
+		{
+			schema:      "legacy",
+			measurement: "Microsoft.Compute/HighCostGet3Min",
+			tags: map[string]string{
+				"provider": "Microsoft.Compute", "operation": "HighCostGet", "window": "3m", "scope": "resource", "region": "regional",
+			},
+		},
+		{
+			schema:      "tagged",
+			measurement: "azure_arm_ratelimit",
+			tags: map[string]string{
+				"bucket": "Microsoft.Compute/HighCostGet3Min", "provider": "Microsoft.Compute", "operation": "HighCostGet",
+				"window": "3m", "scope": "resource", "region": "regional",
+				"subscription": "sub", "resource_group": "rg", "node": "node0", "cluster": "aks",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.schema, func(t *testing.T) {
+			points, err := influxPoints(InfluxDBServer{Schema: tt.schema, Measurement: "azure_arm_ratelimit"}, poll, "requestRemaining")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(points) != 1 {
+				t.Fatalf("%d points, want 1", len(points))
+			}
+			if name := points[0].Name(); name != tt.measurement {
+				t.Errorf("measurement = %q, want %q", name, tt.measurement)
+			}
+			if tags := points[0].Tags(); !reflect.DeepEqual(tags, tt.tags) {
+				t.Errorf("tags = %v, want %v", tags, tt.tags)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package outputs
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	remainingDesc = prometheus.NewDesc(
+		"azurerm_api_resource_request_remaining_count",
+		"The number of requests left for the resource type.",
+		bucketLabelNames, nil)
+	consumptionRateDesc = prometheus.NewDesc(
+		"azurerm_api_resource_request_consumption_rate",
+		"The number of requests of the resource type consumed per second since its last refill.",
+		bucketLabelNames, nil)
+	exhaustionDesc = prometheus.NewDesc(
+		"azurerm_api_resource_request_exhaustion_seconds",
+		"The projected time left before no request of the resource type remains, only set if it is projected to run out.",
+		bucketLabelNames, nil)
+	requestsConsumedDesc = prometheus.NewDesc(
+		"azurerm_api_resource_requests_consumed_total",
+		"The number of requests of the resource type consumed, summed from the decreases between successive polls.",
+		bucketLabelNames, nil)
+	resetsDesc = prometheus.NewDesc(
+		"azurerm_api_resource_request_resets_total",
+		"The number of times requests of the resource type came back between successive polls.",
+		bucketLabelNames, nil)
+	probeSuccessDesc = prometheus.NewDesc(
+		"azurerm_api_probe_success",
+		"Whether the last request of the probe returned a StatusCode of 200.",
+		[]string{"probe"}, nil)
+	probeStatusCodeDesc = prometheus.NewDesc(
+		"azurerm_api_probe_status_code",
+		"The StatusCode returned by the last request of the probe, 0 if no response was received.",
+		[]string{"probe"}, nil)
+	throttledRequestsDesc = prometheus.NewDesc(
+		"azurerm_api_throttled_requests_total",
+		"The number of requests of the probe throttled by Azure API, by bucket with the fewest requests remaining.",
+		[]string{"probe", "bucket"}, nil)
+	throttledRetryAfterDesc = prometheus.NewDesc(
+		"azurerm_api_throttled_retry_after_seconds",
+		"The Retry-After of the last throttled request of the probe.",
+		[]string{"probe"}, nil)
+	alertLevelDesc = prometheus.NewDesc(
+		"azurerm_api_alert_level",
+		"The level of the alert rule for the resource type: 0 when ok, 1 on warning and 2 when critical.",
+		alertLabelNames, nil)
+	lastPollDesc = prometheus.NewDesc(
+		"azurerm_api_last_poll_timestamp_seconds",
+		"Unix time at which the exposed values were collected from Azure API.",
+		nil, nil)
+)
+
+func init() {
+	RegisterSink("prometheus", func() (Sink, error) {
+		if err := ServePrometheus(); err != nil {
+			return nil, err
+		}
+		return sinkFunc{"prometheus", func(poll Poll) error {
+			WriteOutputPrometheus(poll)
+			return nil
+		}}, nil
+	})
+}
+
+// PrometheusServer This struct contains the address the metrics endpoint listens on
+type PrometheusServer struct {
+	ListenAddress string `yaml:"listenAddress"`
+}
+
+var prometheusServer *PrometheusServer
+
+// SetPrometheusConfig Sets the server config used instead of the one generated from environment variables
+func SetPrometheusConfig(server PrometheusServer) {
+	prometheusServer = &server
+}
+
+// GetPrometheusConfig Returns the server config set through SetPrometheusConfig, or generates one from
+// environment variables if none was set
+func GetPrometheusConfig() PrometheusServer {
+	if prometheusServer != nil {
+		return *prometheusServer
+	}
+	return PrometheusServer{}.WithEnvironment()
+}
+
+// WithEnvironment Returns the server config overridden by the environment variables that are set,
+// with defaults for the fields left empty
+func (s PrometheusServer) WithEnvironment() PrometheusServer {
+	if address := os.Getenv("PROMETHEUS_LISTEN_ADDRESS"); address != "" {
+		s.ListenAddress = address
+	}
+	if s.ListenAddress == "" {
+		s.ListenAddress = ":8080"
+	}
+	return s
+}
+
+// Validate reports whether the listen address is valid
+func (s PrometheusServer) Validate() error {
+	if _, _, err := net.SplitHostPort(s.ListenAddress); err != nil {
+		return fmt.Errorf("invalid listen address %q: %v", s.ListenAddress, err)
+	}
+	return nil
+}
+
+// throttleKey identifies the series of throttled requests
+type throttleKey struct {
+	probe  string
+	bucket string
+}
+
+// snapshotCollector exposes the values of the last poll at scrape time, and the throttled
+// requests of every poll so far
+type snapshotCollector struct {
+	mu         sync.RWMutex
+	samples    []Sample
+	statuses   []ProbeStatus
+	alerts     []Alert
+	throttled  map[throttleKey]int
+	retryAfter map[string]time.Duration
+	polledAt   time.Time
+}
+
+var snapshot = &snapshotCollector{
+	throttled:  map[throttleKey]int{},
+	retryAfter: map[string]time.Duration{},
+}
+
+func (c *snapshotCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- remainingDesc
+	ch <- consumptionRateDesc
+	ch <- exhaustionDesc
+	ch <- requestsConsumedDesc
+	ch <- resetsDesc
+	ch <- probeSuccessDesc
+	ch <- probeStatusCodeDesc
+	ch <- throttledRequestsDesc
+	ch <- throttledRetryAfterDesc
+	ch <- alertLevelDesc
+	ch <- lastPollDesc
+}
+
+func (c *snapshotCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.polledAt.IsZero() {
+		return
+	}
+
+	for _, sample := range c.samples {
+		labels := sample.Bucket.labelValues()
+		remaining := prometheus.MustNewConstMetric(remainingDesc, prometheus.GaugeValue, float64(sample.Remaining), labels...)
+		if sample.Stale {
+			// a value held from an earlier response is exposed with the time it was collected at
+			remaining = prometheus.NewMetricWithTimestamp(sample.Time, remaining)
+		}
+		ch <- remaining
+		collectCounters(ch, sample)
+		if sample.Forecast == nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(consumptionRateDesc, prometheus.GaugeValue, sample.Forecast.Rate, labels...)
+		if sample.Forecast.Exhausts {
+			ch <- prometheus.MustNewConstMetric(exhaustionDesc, prometheus.GaugeValue, sample.Forecast.TimeToExhaustion.Seconds(), labels...)
+		}
+	}
+	for _, status := range c.statuses {
+		success := 0.0
+		if status.Succeeded() {
+			success = 1
+		}
+		ch <- prometheus.MustNewConstMetric(probeSuccessDesc, prometheus.GaugeValue, success, status.Probe)
+		ch <- prometheus.MustNewConstMetric(probeStatusCodeDesc, prometheus.GaugeValue, float64(status.StatusCode), status.Probe)
+	}
+	for key, count := range c.throttled {
+		ch <- prometheus.MustNewConstMetric(throttledRequestsDesc, prometheus.CounterValue, float64(count), key.probe, key.bucket)
+	}
+	for probe, retryAfter := range c.retryAfter {
+		ch <- prometheus.MustNewConstMetric(throttledRetryAfterDesc, prometheus.GaugeValue, retryAfter.Seconds(), probe)
+	}
+	for _, alert := range c.alerts {
+		ch <- prometheus.MustNewConstMetric(alertLevelDesc, prometheus.GaugeValue, float64(alert.Level), alert.labelValues()...)
+	}
+	ch <- prometheus.MustNewConstMetric(lastPollDesc, prometheus.GaugeValue, float64(c.polledAt.UnixNano())/1e9)
+}
+
+// collectCounters sends the counters of the sample, if it has any
+func collectCounters(ch chan<- prometheus.Metric, sample Sample) {
+	if sample.Counters == nil {
+		return
+	}
+	labels := sample.Bucket.labelValues()
+	ch <- prometheus.MustNewConstMetric(requestsConsumedDesc, prometheus.CounterValue, float64(sample.Counters.Consumed), labels...)
+	ch <- prometheus.MustNewConstMetric(resetsDesc, prometheus.CounterValue, float64(sample.Counters.Resets), labels...)
+}
+
+// WriteOutputPrometheus replaces the values exposed on the metrics endpoint with the ones of the
+// poll and counts its throttled requests
+func WriteOutputPrometheus(poll Poll) {
+	snapshot.mu.Lock()
+	defer snapshot.mu.Unlock()
+
+	snapshot.samples = poll.Samples()
+	snapshot.statuses = poll.Statuses
+	snapshot.alerts = poll.Alerts
+	for _, throttle := range poll.Throttles {
+		snapshot.throttled[throttleKey{throttle.Probe, throttle.Bucket}]++
+		snapshot.retryAfter[throttle.Probe] = throttle.RetryAfter
+	}
+	// the values of a poll may be written some time after being collected, e.g. in proxy mode
+	snapshot.polledAt = poll.Time
+	if snapshot.polledAt.IsZero() {
+		snapshot.polledAt = time.Now()
+	}
+
+	log.Println("Successfully updated Prometheus metrics")
+}
+
+// ServePrometheus starts the HTTP listener exposing the metrics endpoint on /metrics
+func ServePrometheus() error {
+	s := GetPrometheusConfig()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(snapshot)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	listener, err := net.Listen("tcp", s.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", s.ListenAddress, err)
+	}
+
+	log.Printf("Serving Prometheus metrics on %s/metrics", s.ListenAddress)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Printf("failed to serve Prometheus metrics: %v", err)
+		}
+	}()
+	return nil
+}
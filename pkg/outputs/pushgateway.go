@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -15,39 +16,295 @@ var (
 		Name: "azurerm_api_resource_request_remaining_count",
 		Help: "The number of requests left for the resource type.",
 	})
+	probeSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "azurerm_api_probe_success",
+		Help: "Whether the last request of the probe returned a StatusCode of 200.",
+	}, []string{"probe"})
+	probeStatusCode = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "azurerm_api_probe_status_code",
+		Help: "The StatusCode returned by the last request of the probe, 0 if no response was received.",
+	}, []string{"probe"})
+	throttledRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "azurerm_api_throttled_requests_total",
+		Help: "The number of requests of the probe throttled by Azure API, by bucket with the fewest requests remaining.",
+	}, []string{"probe", "bucket"})
+	throttledRetryAfter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "azurerm_api_throttled_retry_after_seconds",
+		Help: "The Retry-After of the last throttled request of the probe.",
+	}, []string{"probe"})
+	remainingVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "azurerm_api_resource_request_remaining_count",
+		Help: "The number of requests left for the resource type.",
+	}, bucketLabelNames)
+	consumptionRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "azurerm_api_resource_request_consumption_rate",
+		Help: "The number of requests of the resource type consumed per second since its last refill.",
+	}, bucketLabelNames)
+	exhaustion = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "azurerm_api_resource_request_exhaustion_seconds",
+		Help: "The projected time left before no request of the resource type remains, only set if it is projected to run out.",
+	}, bucketLabelNames)
+	alertLevel = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "azurerm_api_alert_level",
+		Help: "The level of the alert rule for the resource type: 0 when ok, 1 on warning and 2 when critical.",
+	}, alertLabelNames)
 )
 
+func init() {
+	RegisterSink("pushgateway", func() (Sink, error) {
+		return sinkFunc{"pushgateway", WriteOutputPushGateway}, nil
+	})
+}
+
 // PushGatewayServer This struct contains the information necessary to connect to a PushGateway server
 // such as host and port
 type PushGatewayServer struct {
-	Host string
-	Port string
+	Host string `yaml:"host"`
+	Port string `yaml:"port"`
+	// LegacyLayout pushes one unlabeled gauge per bucket, grouped by type, as done before the labeled layout
+	LegacyLayout bool `yaml:"legacyLayout"`
 }
 
-// GetPushGatewayConfig Generates a server config from environment variables
+var pushGatewayServer *PushGatewayServer
+
+// SetPushGatewayConfig Sets the server config used instead of the one generated from environment variables
+func SetPushGatewayConfig(server PushGatewayServer) {
+	pushGatewayServer = &server
+}
+
+// GetPushGatewayConfig Returns the server config set through SetPushGatewayConfig, or generates one from
+// environment variables if none was set
 func GetPushGatewayConfig() PushGatewayServer {
-	server := PushGatewayServer{
-		Host: os.Getenv("PUSHGATEWAY_HOST"),
-		Port: os.Getenv("PUSHGATEWAY_PORT"),
+	if pushGatewayServer != nil {
+		return *pushGatewayServer
+	}
+	return PushGatewayServer{}.WithEnvironment()
+}
+
+// WithEnvironment Returns the server config overridden by the environment variables that are set
+func (s PushGatewayServer) WithEnvironment() PushGatewayServer {
+	if host := os.Getenv("PUSHGATEWAY_HOST"); host != "" {
+		s.Host = host
+	}
+	if port := os.Getenv("PUSHGATEWAY_PORT"); port != "" {
+		s.Port = port
 	}
-	return server
+	if legacy, err := strconv.ParseBool(os.Getenv("PUSHGATEWAY_LEGACY_LAYOUT")); err == nil {
+		s.LegacyLayout = legacy
+	}
+	return s
 }
 
-// WriteOutputPushGateway pushes metrics to the pushgatewayw
-func WriteOutputPushGateway(values map[string]int) {
+// Validate reports the first setting preventing a push to the PushGateway
+func (s PushGatewayServer) Validate() error {
+	if s.Host == "" {
+		return fmt.Errorf("host is required")
+	}
+	if _, err := strconv.ParseUint(s.Port, 10, 16); err != nil {
+		return fmt.Errorf("invalid port %q", s.Port)
+	}
+	return nil
+}
+
+// WriteOutputPushGateway pushes the values and the outcome of every probe of a poll to the pushgateway
+// in a single push. The metadata becomes part of the grouping key, so that several limitometers can
+// push to the same pushgateway.
+func WriteOutputPushGateway(poll Poll) error {
 	s := GetPushGatewayConfig()
+	if s.LegacyLayout {
+		// every group is pushed on its own, a failing one does not prevent the others from being pushed
+		var failed error
+		for _, err := range []error{
+			writeLegacyPushGateway(s, poll.Values),
+			writeForecastsPushGateway(s, poll),
+			writeCountersPushGateway(s, poll),
+			writeAlertsPushGateway(s, poll.Alerts),
+			writeProbeStatusPushGateway(s, poll.Statuses, poll.Throttles),
+		} {
+			if err != nil && failed == nil {
+				failed = err
+			}
+		}
+		return failed
+	}
+
+	remainingVec.Reset()
+	for _, sample := range poll.Samples() {
+		remainingVec.WithLabelValues(sample.Bucket.labelValues()...).Set(float64(sample.Remaining))
+	}
+	setForecasts(poll)
+	setAlerts(poll.Alerts)
+	setProbeStatus(poll.Statuses)
+	addThrottles(poll.Throttles)
+
+	pusher := push.New(fmt.Sprintf("http://%s:%s", s.Host, s.Port), "limitometer").
+		Collector(remainingVec).
+		Collector(consumptionRate).
+		Collector(exhaustion).
+		Collector(countersCollector{poll.Samples()}).
+		Collector(alertLevel).
+		Collector(probeSuccess).
+		Collector(probeStatusCode).
+		Collector(throttledRequests).
+		Collector(throttledRetryAfter)
+	for name, value := range poll.Metadata.Labels() {
+		pusher.Grouping(name, value)
+	}
+	if err := pusher.Push(); err != nil {
+		return err
+	}
+
+	log.Println("Successfully wrote to PushGateway")
+	return nil
+}
 
+// writeLegacyPushGateway pushes one gauge per bucket, grouped by the bucket name only so that the
+// groups pushed before the labeled layout keep being updated
+func writeLegacyPushGateway(s PushGatewayServer, values map[string]int) error {
 	for k, v := range values {
 		pusher := push.New(fmt.Sprintf("http://%s:%s", s.Host, s.Port), "limitometer")
 		remaining.Set(float64(v))
 		// Note that / cannot be used as part of a label value or the job name,
 		// even if escaped as %2F. (The decoding happens before the path routing kicks in,
 		//cf. the Go documentation of URL.Path.)
-		pusher.Collector(remaining).Grouping("type", strings.Replace(k, "/", "\\", 1))
+		pusher.Collector(remaining).
+			Grouping("type", strings.Replace(k, "/", "\\", 1))
 		if err := pusher.Push(); err != nil {
-			log.Fatal(err)
+			return err
 		}
 	}
 
 	log.Println("Successfully wrote to PushGateway")
+	return nil
+}
+
+// writeForecastsPushGateway pushes the forecast of every bucket in a single group
+func writeForecastsPushGateway(s PushGatewayServer, poll Poll) error {
+	if len(poll.Forecasts) == 0 {
+		return nil
+	}
+	setForecasts(poll)
+
+	pusher := push.New(fmt.Sprintf("http://%s:%s", s.Host, s.Port), "limitometer")
+	// type is a label of the bucket metrics, it cannot be part of the grouping key as well
+	pusher.Collector(consumptionRate).Collector(exhaustion).
+		Grouping("group", "forecasts")
+	if err := pusher.Push(); err != nil {
+		return err
+	}
+
+	log.Println("Successfully wrote forecasts to PushGateway")
+	return nil
+}
+
+// writeCountersPushGateway pushes the counters of every bucket in a single group
+func writeCountersPushGateway(s PushGatewayServer, poll Poll) error {
+	if len(poll.Counters) == 0 {
+		return nil
+	}
+
+	pusher := push.New(fmt.Sprintf("http://%s:%s", s.Host, s.Port), "limitometer")
+	pusher.Collector(countersCollector{poll.Samples()}).
+		Grouping("group", "counters")
+	if err := pusher.Push(); err != nil {
+		return err
+	}
+
+	log.Println("Successfully wrote counters to PushGateway")
+	return nil
+}
+
+// writeAlertsPushGateway pushes the state of every alert in a single group
+func writeAlertsPushGateway(s PushGatewayServer, alerts []Alert) error {
+	if len(alerts) == 0 {
+		return nil
+	}
+	setAlerts(alerts)
+
+	pusher := push.New(fmt.Sprintf("http://%s:%s", s.Host, s.Port), "limitometer")
+	pusher.Collector(alertLevel).
+		Grouping("group", "alerts")
+	if err := pusher.Push(); err != nil {
+		return err
+	}
+
+	log.Println("Successfully wrote alerts to PushGateway")
+	return nil
+}
+
+// countersCollector exposes the counters of the samples, which are kept by the History rather than
+// incremented by the collector
+type countersCollector struct {
+	samples []Sample
+}
+
+func (c countersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- requestsConsumedDesc
+	ch <- resetsDesc
+}
+
+func (c countersCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, sample := range c.samples {
+		collectCounters(ch, sample)
+	}
+}
+
+// writeProbeStatusPushGateway pushes the outcome of every probe in its own group
+func writeProbeStatusPushGateway(s PushGatewayServer, statuses []ProbeStatus, throttles []Throttle) error {
+	setProbeStatus(statuses)
+	addThrottles(throttles)
+
+	pusher := push.New(fmt.Sprintf("http://%s:%s", s.Host, s.Port), "limitometer")
+	pusher.Collector(probeSuccess).Collector(probeStatusCode).
+		Collector(throttledRequests).Collector(throttledRetryAfter).
+		Grouping("type", "probes")
+	if err := pusher.Push(); err != nil {
+		return err
+	}
+
+	log.Println("Successfully wrote probe status to PushGateway")
+	return nil
+}
+
+func setForecasts(poll Poll) {
+	consumptionRate.Reset()
+	exhaustion.Reset()
+	for _, sample := range poll.Samples() {
+		if sample.Forecast == nil {
+			continue
+		}
+		labels := sample.Bucket.labelValues()
+		consumptionRate.WithLabelValues(labels...).Set(sample.Forecast.Rate)
+		if sample.Forecast.Exhausts {
+			exhaustion.WithLabelValues(labels...).Set(sample.Forecast.TimeToExhaustion.Seconds())
+		}
+	}
+}
+
+func setAlerts(alerts []Alert) {
+	alertLevel.Reset()
+	for _, alert := range alerts {
+		alertLevel.WithLabelValues(alert.labelValues()...).Set(float64(alert.Level))
+	}
+}
+
+func setProbeStatus(statuses []ProbeStatus) {
+	probeSuccess.Reset()
+	probeStatusCode.Reset()
+	for _, status := range statuses {
+		success := 0.0
+		if status.Succeeded() {
+			success = 1
+		}
+		probeSuccess.WithLabelValues(status.Probe).Set(success)
+		probeStatusCode.WithLabelValues(status.Probe).Set(float64(status.StatusCode))
+	}
+}
+
+// addThrottles counts the throttled requests, the counters are kept for the lifetime of the process
+func addThrottles(throttles []Throttle) {
+	for _, throttle := range throttles {
+		throttledRequests.WithLabelValues(throttle.Probe, throttle.Bucket).Inc()
+		throttledRetryAfter.WithLabelValues(throttle.Probe).Set(throttle.RetryAfter.Seconds())
+	}
 }
@@ -0,0 +1,101 @@
+package outputs
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Poll This struct contains everything collected during a single poll of Azure API
+type Poll struct {
+	Values map[string]int
+	// Stale are the values held from an earlier response instead of being collected by this poll,
+	// keyed by bucket name along with the time of that response
+	Stale     map[string]time.Time
+	Statuses  []ProbeStatus
+	Throttles []Throttle
+	// Forecasts are keyed by bucket name, see History
+	Forecasts map[string]Forecast
+	// Counters are keyed by bucket name, see History
+	Counters map[string]Counters
+	// Alerts are the states of the alert rules, for every bucket they applied to so far
+	Alerts   []Alert
+	Metadata Metadata
+	// Target describes where the poll was made in full, whereas Metadata only holds the labels
+	// selected for the outputs
+	Target Metadata
+	Time   time.Time
+}
+
+// Sink is a target the values of every poll are written to
+type Sink interface {
+	Name() string
+	Write(poll Poll) error
+}
+
+// SinkFactory creates a sink, it is called once at startup
+type SinkFactory func() (Sink, error)
+
+var sinks = map[string]SinkFactory{}
+
+// RegisterSink makes a sink available under the given name
+func RegisterSink(name string, factory SinkFactory) {
+	if _, exists := sinks[name]; exists {
+		panic(fmt.Sprintf("sink %q registered twice", name))
+	}
+	sinks[name] = factory
+}
+
+// SinkNames returns the names of all registered sinks
+func SinkNames() []string {
+	names := make([]string, 0, len(sinks))
+	for name := range sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewSinks creates the sinks with the given names
+func NewSinks(names []string) ([]Sink, error) {
+	var created []Sink
+	for _, name := range names {
+		factory, exists := sinks[strings.ToLower(name)]
+		if !exists {
+			return nil, fmt.Errorf("unknown output %q, supported values are: [%s]", name, strings.Join(SinkNames(), "|"))
+		}
+		sink, err := factory()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create output %s: %v", name, err)
+		}
+		created = append(created, sink)
+	}
+	return created, nil
+}
+
+// WriteAll writes the poll to every sink. A failing sink is logged and does not
+// prevent the poll from being written to the other sinks.
+func WriteAll(sinks []Sink, poll Poll) {
+	for _, sink := range sinks {
+		log.Printf("Writing to database: %s", sink.Name())
+		if err := sink.Write(poll); err != nil {
+			log.Printf("failed to write to %s: %v", sink.Name(), err)
+		}
+	}
+}
+
+// sinkFunc adapts a write function to the Sink interface
+type sinkFunc struct {
+	name  string
+	write func(poll Poll) error
+}
+
+func (s sinkFunc) Name() string {
+	return s.name
+}
+
+func (s sinkFunc) Write(poll Poll) error {
+	return s.write(poll)
+}
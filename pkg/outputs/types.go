@@ -0,0 +1,68 @@
+package outputs
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// RegionGlobal denotes buckets of the ARM token bucket model that are shared by all regions
+	RegionGlobal = "global"
+	// RegionRegional denotes buckets that are counted by the ARM region serving the request
+	RegionRegional = "regional"
+)
+
+// LabelNames are the optional labels that can be added to the outputs, see Metadata
+var LabelNames = []string{"subscription", "resource_group", "cluster", "node"}
+
+// Metadata This struct describes where the values were collected. Empty fields are left out of the outputs.
+type Metadata struct {
+	Subscription  string
+	ResourceGroup string
+	Cluster       string
+	Node          string
+}
+
+// Labels Returns the non empty fields keyed by their label name
+func (m Metadata) Labels() map[string]string {
+	labels := map[string]string{}
+	for name, value := range map[string]string{
+		"subscription":   m.Subscription,
+		"resource_group": m.ResourceGroup,
+		"cluster":        m.Cluster,
+		"node":           m.Node,
+	} {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	return labels
+}
+
+// ProbeStatus This struct contains the outcome of a single probe of a poll
+type ProbeStatus struct {
+	Probe      string
+	StatusCode int
+	Error      string
+}
+
+// Succeeded Reports whether the probe returned a StatusCode of 200
+func (s ProbeStatus) Succeeded() bool {
+	return s.Error == "" && s.StatusCode == 200
+}
+
+// Throttle This struct describes a request of a probe that Azure API throttled with a 429
+type Throttle struct {
+	Probe string
+	// Bucket is the bucket with the fewest requests remaining in the response, empty if none was returned
+	Bucket     string
+	RetryAfter time.Duration
+}
+
+// Region Returns whether the bucket is a global or a regional one
+func Region(bucket string) string {
+	if strings.HasPrefix(bucket, "SubIDGlobal") || strings.HasPrefix(bucket, "TenantGlobal") {
+		return RegionGlobal
+	}
+	return RegionRegional
+}
@@ -0,0 +1,119 @@
+package probes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/common"
+)
+
+func init() {
+	Register("getvm", DefaultVM, func(client common.AzureClient, target Target) Probe {
+		return probeFunc{"getvm", CostLow, func(ctx context.Context) (autorest.Response, error) {
+			vm, err := client.GetVM(ctx, target.Node)
+			return vm.Response, err
+		}}
+	})
+	Register("getnic", DefaultVM, func(client common.AzureClient, target Target) Probe {
+		return probeFunc{"getnic", CostLow, func(ctx context.Context) (autorest.Response, error) {
+			nic, err := client.GetNicFromVMName(ctx, target.Node)
+			return nic.Response, err
+		}}
+	})
+	Register("listlb", DefaultAlways, func(client common.AzureClient, target Target) Probe {
+		return probeFunc{"listlb", CostHigh, func(ctx context.Context) (autorest.Response, error) {
+			page, err := client.GetAllLoadBalancer(ctx)
+			return page.Response().Response, err
+		}}
+	})
+	Register("listvm", DefaultVM, func(client common.AzureClient, target Target) Probe {
+		return probeFunc{"listvm", CostHigh, func(ctx context.Context) (autorest.Response, error) {
+			page, err := client.GetAllVM(ctx)
+			return page.Response().Response, err
+		}}
+	})
+	Register("listnic", DefaultAlways, func(client common.AzureClient, target Target) Probe {
+		return probeFunc{"listnic", CostHigh, func(ctx context.Context) (autorest.Response, error) {
+			page, err := client.GetAllNics(ctx)
+			return page.Response().Response, err
+		}}
+	})
+	Register("putvm", DefaultNever, func(client common.AzureClient, target Target) Probe {
+		return probeFunc{"putvm", CostWrite, func(ctx context.Context) (autorest.Response, error) {
+			return client.PutVM(ctx, target.Node)
+		}}
+	})
+	Register("getvmss", DefaultScaleSet, func(client common.AzureClient, target Target) Probe {
+		return probeFunc{"getvmss", CostLow, func(ctx context.Context) (autorest.Response, error) {
+			vmss, err := client.GetVMSS(ctx, target.ScaleSet)
+			return vmss.Response, err
+		}}
+	})
+	Register("listvmss", DefaultScaleSet, func(client common.AzureClient, target Target) Probe {
+		return probeFunc{"listvmss", CostHigh, func(ctx context.Context) (autorest.Response, error) {
+			page, err := client.GetAllVMSS(ctx)
+			return page.Response().Response, err
+		}}
+	})
+	Register("getvmssvm", DefaultScaleSet, func(client common.AzureClient, target Target) Probe {
+		return probeFunc{"getvmssvm", CostLow, func(ctx context.Context) (autorest.Response, error) {
+			vm, err := client.GetVMSSVM(ctx, target.ScaleSet, target.Instance)
+			return vm.Response, err
+		}}
+	})
+	Register("listvmssvm", DefaultScaleSet, func(client common.AzureClient, target Target) Probe {
+		return probeFunc{"listvmssvm", CostHigh, func(ctx context.Context) (autorest.Response, error) {
+			page, err := client.GetAllVMSSVMs(ctx, target.ScaleSet)
+			return page.Response().Response, err
+		}}
+	})
+	Register("putvmssvm", DefaultNever, func(client common.AzureClient, target Target) Probe {
+		return probeFunc{"putvmssvm", CostWrite, func(ctx context.Context) (autorest.Response, error) {
+			return client.PutVMSSVM(ctx, target.ScaleSet, target.Instance)
+		}}
+	})
+}
+
+// RegisterResourceProbe registers a probe performing a GET on an arbitrary ARM resource.
+// The definition has the form name=path@api-version[:costclass], for example
+// "listdisks=/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/disks@2019-07-01:high"
+// Registered resource probes are enabled by default for every target.
+func RegisterResourceProbe(definition string) error {
+	name, rest := splitOnce(definition, "=")
+	if name == "" || rest == "" {
+		return fmt.Errorf("invalid resource probe %q, expected name=path@api-version[:costclass]", definition)
+	}
+	path, rest := splitOnce(rest, "@")
+	apiVersion, costClass := splitOnce(rest, ":")
+	if path == "" || apiVersion == "" {
+		return fmt.Errorf("invalid resource probe %q, expected name=path@api-version[:costclass]", definition)
+	}
+	if costClass == "" {
+		costClass = string(CostLow)
+	}
+	switch CostClass(costClass) {
+	case CostLow, CostHigh, CostWrite:
+	default:
+		return fmt.Errorf("invalid cost class %q for resource probe %q, supported values are: [low|high|write]", costClass, name)
+	}
+	if _, exists := registry[name]; exists {
+		return fmt.Errorf("probe %q is already registered", name)
+	}
+
+	Register(name, DefaultAlways, func(client common.AzureClient, target Target) Probe {
+		return probeFunc{name, CostClass(costClass), func(ctx context.Context) (autorest.Response, error) {
+			return client.GetResource(ctx, path, apiVersion)
+		}}
+	})
+	return nil
+}
+
+func splitOnce(s string, sep string) (string, string) {
+	parts := strings.SplitN(s, sep, 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
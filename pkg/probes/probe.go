@@ -0,0 +1,205 @@
+package probes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/common"
+)
+
+// CostClass describes which kind of ARM throttling bucket a probe spends quota from
+type CostClass string
+
+const (
+	// CostLow denotes cheap single resource reads, e.g. LowCostGet
+	CostLow CostClass = "low"
+	// CostHigh denotes expensive reads such as list operations, e.g. HighCostGet
+	CostHigh CostClass = "high"
+	// CostWrite denotes PUT/DELETE operations
+	CostWrite CostClass = "write"
+)
+
+// Result contains the parts of an ARM response that the limitometer cares about
+type Result struct {
+	StatusCode int
+	Header     http.Header
+}
+
+// Probe is a single ARM request made to observe the remaining requests headers
+type Probe interface {
+	Name() string
+	CostClass() CostClass
+	Run(ctx context.Context) (Result, error)
+}
+
+// Target contains the resources that the probes are made against
+type Target struct {
+	Node string
+	// ScaleSet and Instance select a VM Scale Set instance instead of an availability set VM
+	ScaleSet string
+	Instance string
+}
+
+// UsesScaleSet reports whether the target is a VM Scale Set instance
+func (t Target) UsesScaleSet() bool {
+	return t.ScaleSet != ""
+}
+
+// InstanceFromNodeName derives the instance ID of a VM Scale Set instance from its computer
+// name, which is the scale set name followed by the instance ID as six base 36 digits, e.g.
+// "aks-nodepool1-12345678-vmss00000a" is instance 10 of "aks-nodepool1-12345678-vmss".
+// The VM name reported by the Instance Metadata Service, e.g. "aks-nodepool1-12345678-vmss_10",
+// is supported as well.
+func InstanceFromNodeName(nodename string, scaleSet string) (string, bool) {
+	if parts := strings.SplitN(nodename, "_", 2); len(parts) == 2 && strings.EqualFold(parts[0], scaleSet) {
+		if _, err := strconv.ParseUint(parts[1], 10, 64); err == nil {
+			return parts[1], true
+		}
+		return "", false
+	}
+
+	suffix := strings.TrimPrefix(strings.ToLower(nodename), strings.ToLower(scaleSet))
+	if len(suffix) != 6 || suffix == strings.ToLower(nodename) {
+		return "", false
+	}
+	instance, err := strconv.ParseUint(suffix, 36, 64)
+	if err != nil {
+		return "", false
+	}
+	return strconv.FormatUint(instance, 10), true
+}
+
+// Default selects for which targets a probe is enabled when no explicit list is configured
+type Default int
+
+const (
+	// DefaultNever probes only run when explicitly enabled
+	DefaultNever Default = iota
+	// DefaultAlways probes run for every target
+	DefaultAlways
+	// DefaultVM probes run for availability set VM targets
+	DefaultVM
+	// DefaultScaleSet probes run for VM Scale Set targets
+	DefaultScaleSet
+)
+
+func (d Default) appliesTo(target Target) bool {
+	switch d {
+	case DefaultAlways:
+		return true
+	case DefaultVM:
+		return !target.UsesScaleSet()
+	case DefaultScaleSet:
+		return target.UsesScaleSet()
+	}
+	return false
+}
+
+// Factory creates a probe for the given client and target
+type Factory func(client common.AzureClient, target Target) Probe
+
+type registration struct {
+	name       string
+	defaultFor Default
+	factory    Factory
+}
+
+var registry = map[string]registration{}
+
+// order keeps the registration order, which is the order the default probes are run in
+var order []string
+
+// Register makes a probe available under the given name. The probe is run when
+// no explicit list is configured and defaultFor applies to the target.
+func Register(name string, defaultFor Default, factory Factory) {
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("probe %q registered twice", name))
+	}
+	registry[name] = registration{name, defaultFor, factory}
+	order = append(order, name)
+}
+
+// Names returns the names of all registered probes
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Defaults returns the names of the probes enabled by default for the target, in registration order
+func Defaults(target Target) []string {
+	var names []string
+	for _, name := range order {
+		if registry[name].defaultFor.appliesTo(target) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// New builds the enabled probes. An empty enabled list selects the default probes
+// of the target, the disabled list is removed from that selection.
+func New(client common.AzureClient, target Target, enabled []string, disabled []string) ([]Probe, error) {
+	if len(enabled) == 0 {
+		enabled = Defaults(target)
+	}
+
+	skip := map[string]bool{}
+	for _, name := range disabled {
+		if _, exists := registry[name]; !exists {
+			return nil, fmt.Errorf("unknown probe %q, supported values are: [%s]", name, strings.Join(Names(), "|"))
+		}
+		skip[name] = true
+	}
+
+	var probes []Probe
+	for _, name := range enabled {
+		registration, exists := registry[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown probe %q, supported values are: [%s]", name, strings.Join(Names(), "|"))
+		}
+		if skip[name] {
+			continue
+		}
+		probes = append(probes, registration.factory(client, target))
+	}
+	return probes, nil
+}
+
+// probeFunc adapts a function making an autorest request to the Probe interface
+type probeFunc struct {
+	name      string
+	costClass CostClass
+	run       func(ctx context.Context) (autorest.Response, error)
+}
+
+func (p probeFunc) Name() string {
+	return p.name
+}
+
+func (p probeFunc) CostClass() CostClass {
+	return p.costClass
+}
+
+func (p probeFunc) Run(ctx context.Context) (Result, error) {
+	response, err := p.run(ctx)
+	return resultFromResponse(response), err
+}
+
+func resultFromResponse(response autorest.Response) Result {
+	if response.Response == nil {
+		return Result{Header: http.Header{}}
+	}
+	return Result{
+		StatusCode: response.StatusCode,
+		Header:     response.Header,
+	}
+}
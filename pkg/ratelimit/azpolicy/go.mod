@@ -0,0 +1,10 @@
+module github.com/hetalsonavane/azure-request-limitometer/pkg/ratelimit/azpolicy
+
+go 1.18
+
+require github.com/Azure/azure-sdk-for-go/sdk/azcore v1.0.0
+
+require (
+	golang.org/x/net v0.0.0-20220425223048-2871e0cb64e4 // indirect
+	golang.org/x/text v0.3.7 // indirect
+)
@@ -0,0 +1,44 @@
+// Package azpolicy records the remaining requests of Azure Resource Manager into a
+// ratelimit.Snapshot from the clients of the track-2 Azure SDK, built on azcore.
+//
+// The package is a module of its own depending on azcore only, so that it can be required
+// without the limitometer module, which it does not import.
+package azpolicy
+
+import (
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// Recorder records the headers of a response, it is implemented by *ratelimit.Snapshot
+type Recorder interface {
+	Record(h http.Header)
+}
+
+// Policy is an azcore pipeline policy recording the headers of every response into a snapshot.
+// It is added to the PerRetryPolicies of the client options so that the responses of retried
+// requests, throttled ones included, are recorded as well, e.g.
+//
+//	options := &arm.ClientOptions{ClientOptions: policy.ClientOptions{
+//		PerRetryPolicies: []policy.Policy{azpolicy.New(ratelimit.Default)},
+//	}}
+type Policy struct {
+	// Recorder records the headers, nothing is recorded if nil
+	Recorder Recorder
+}
+
+// New returns a policy recording into recorder, usually a *ratelimit.Snapshot
+func New(recorder Recorder) *Policy {
+	return &Policy{Recorder: recorder}
+}
+
+// Do sends the request down the pipeline and records the headers of its response, error
+// responses included
+func (p *Policy) Do(req *policy.Request) (*http.Response, error) {
+	resp, err := req.Next()
+	if resp != nil && p.Recorder != nil {
+		p.Recorder.Record(resp.Header)
+	}
+	return resp, err
+}
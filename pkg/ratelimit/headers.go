@@ -0,0 +1,119 @@
+// Package ratelimit records the remaining requests Azure Resource Manager returns in the
+// x-ms-ratelimit-remaining-* headers of its responses. The headers of every response made
+// through a RoundTripper or a SendDecorator of the package are recorded into a Snapshot,
+// whose values can be written to the outputs of the limitometer.
+package ratelimit
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Example Request Headers:
+// 'x-ms-ratelimit-remaining-resource': 'Microsoft.Compute/HighCostGet3Min;133,Microsoft.Compute/HighCostGet30Min;657'
+// 'x-ms-ratelimit-remaining-resource': 'Microsoft.Compute/LowCostGet3Min;3989,Microsoft.Compute/LowCostGet30Min;31790'
+// 'x-ms-ratelimit-remaining-resource': 'Microsoft.Compute/PutVM3Min;740,Microsoft.Compute/PutVM30Min;3695'
+// `X-Ms-Ratelimit-Remaining-Subscription-Reads: [11535]`
+// `X-Ms-Ratelimit-Remaining-Subscription-Writes: [1199]`
+// `X-Ms-Ratelimit-Remaining-Tenant-Reads: [11999]`
+// `X-Ms-Ratelimit-Remaining-Subscription-Global-Reads: [11999]`
+
+var expectedHeaderField = "X-Ms-Ratelimit-Remaining-Resource"
+var expectedHeaderFormat = regexp.MustCompile(`(Microsoft.\w+\/\w+);(\d+)`)
+
+// subIDHeaders maps the subscription and tenant level headers to the key they are stored under
+var subIDHeaders = []struct {
+	field string
+	key   string
+}{
+	{"X-Ms-Ratelimit-Remaining-Subscription-Reads", "SubIDReads"},
+	{"X-Ms-Ratelimit-Remaining-Subscription-Writes", "SubIDWrites"},
+	{"X-Ms-Ratelimit-Remaining-Subscription-Deletes", "SubIDDeletes"},
+	{"X-Ms-Ratelimit-Remaining-Subscription-Resource-Requests", "SubIDResourceRequests"},
+	{"X-Ms-Ratelimit-Remaining-Subscription-Resource-Entities-Read", "SubIDResourceEntitiesReads"},
+	{"X-Ms-Ratelimit-Remaining-Subscription-Global-Reads", "SubIDGlobalReads"},
+	{"X-Ms-Ratelimit-Remaining-Subscription-Global-Writes", "SubIDGlobalWrites"},
+	{"X-Ms-Ratelimit-Remaining-Subscription-Global-Deletes", "SubIDGlobalDeletes"},
+	{"X-Ms-Ratelimit-Remaining-Tenant-Reads", "TenantReads"},
+	{"X-Ms-Ratelimit-Remaining-Tenant-Writes", "TenantWrites"},
+	{"X-Ms-Ratelimit-Remaining-Tenant-Deletes", "TenantDeletes"},
+	{"X-Ms-Ratelimit-Remaining-Tenant-Resource-Requests", "TenantResourceRequests"},
+	{"X-Ms-Ratelimit-Remaining-Tenant-Resource-Entities-Read", "TenantResourceEntitiesReads"},
+}
+
+// Extract returns the remaining requests of every bucket found in the headers, keyed by bucket
+// name, e.g. Microsoft.Compute/HighCostGet3Min or SubIDReads
+func Extract(h http.Header) map[string]int {
+	requestsRemaining := ExtractResource(h)
+	for k, v := range ExtractSubscription(h) {
+		requestsRemaining[k] = v
+	}
+	return requestsRemaining
+}
+
+// ExtractResource returns the remaining requests of the resource provider buckets of the
+// x-ms-ratelimit-remaining-resource header
+func ExtractResource(h http.Header) (requestsRemaining map[string]int) {
+	requestsRemaining = map[string]int{}
+
+	headerSubfields := strings.Split(h.Get(expectedHeaderField), ",")
+
+	for _, field := range headerSubfields {
+
+		matches := expectedHeaderFormat.FindStringSubmatch(field)
+		if !(len(matches) == 3) {
+			continue
+		}
+
+		requestType := matches[1]
+		requestsLeft, err := strconv.Atoi(matches[2])
+		if err != nil {
+			log.Printf("failed to parse %s header %q: %s\n", expectedHeaderField, field, err)
+			continue
+		}
+		requestsRemaining[requestType] = requestsLeft
+	}
+
+	return requestsRemaining
+}
+
+// ExtractSubscription returns the remaining requests of the subscription and tenant level headers
+func ExtractSubscription(h http.Header) (requestsRemaining map[string]int) {
+	requestsRemaining = map[string]int{}
+	for _, header := range subIDHeaders {
+		headerField := h.Get(header.field)
+		if headerField == "" {
+			continue
+		}
+		requestLeft, err := strconv.Atoi(headerField)
+		if err != nil {
+			log.Printf("failed to parse %s header %q: %s\n", header.field, headerField, err)
+			continue
+		}
+		requestsRemaining[header.key] = requestLeft
+	}
+	return requestsRemaining
+}
+
+// RetryAfter returns the duration of the Retry-After header of a throttled response, given either
+// in seconds or as an HTTP date, and whether the header was found
+func RetryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	value := h.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if date.Before(now) {
+			return 0, true
+		}
+		return date.Sub(now), true
+	}
+	return 0, false
+}
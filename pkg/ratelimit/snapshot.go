@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hetalsonavane/azure-request-limitometer/pkg/outputs"
+)
+
+// Snapshot keeps the last remaining requests recorded for every bucket. It is safe for
+// concurrent use, so a single snapshot can be shared by every client of a process.
+type Snapshot struct {
+	mu      sync.Mutex
+	values  map[string]int
+	updated time.Time
+	flushed time.Time
+}
+
+// Default is the snapshot used by the RoundTripper and the SendDecorator when none is given
+var Default = NewSnapshot()
+
+// NewSnapshot creates an empty snapshot
+func NewSnapshot() *Snapshot {
+	return &Snapshot{values: map[string]int{}}
+}
+
+// Record records the remaining requests found in the headers of a response
+func (s *Snapshot) Record(h http.Header) {
+	values := Extract(h)
+	if len(values) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range values {
+		s.values[k] = v
+	}
+	s.updated = time.Now()
+}
+
+// Values returns a copy of the last remaining requests of every bucket and when they were last updated
+func (s *Snapshot) Values() (map[string]int, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.copyValues(), s.updated
+}
+
+// Flush returns the same as Values, unless nothing was recorded since the previous flush
+func (s *Snapshot) Flush() (map[string]int, time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.updated.After(s.flushed) {
+		return nil, s.updated, false
+	}
+	s.flushed = s.updated
+	return s.copyValues(), s.updated, true
+}
+
+// Poll returns the values of the snapshot as a poll, to be written to outputs through outputs.WriteAll
+func (s *Snapshot) Poll(metadata outputs.Metadata) outputs.Poll {
+	values, updated := s.Values()
+	return outputs.Poll{
+		Values:   values,
+		Metadata: metadata,
+		Time:     updated,
+	}
+}
+
+func (s *Snapshot) copyValues() map[string]int {
+	values := make(map[string]int, len(s.values))
+	for k, v := range s.values {
+		values[k] = v
+	}
+	return values
+}
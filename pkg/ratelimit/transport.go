@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"net/http"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// RoundTripper is an http.RoundTripper recording the headers of every response into a snapshot
+type RoundTripper struct {
+	// Base makes the requests, http.DefaultTransport if nil
+	Base http.RoundTripper
+	// Snapshot records the headers, Default if nil
+	Snapshot *Snapshot
+}
+
+// NewRoundTripper returns a RoundTripper making the requests through base and recording into snapshot
+func NewRoundTripper(base http.RoundTripper, snapshot *Snapshot) *RoundTripper {
+	return &RoundTripper{Base: base, Snapshot: snapshot}
+}
+
+// RoundTrip makes the request and records the headers of its response, error responses included
+func (t *RoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(r)
+	if resp != nil {
+		snapshotOrDefault(t.Snapshot).Record(resp.Header)
+	}
+	return resp, err
+}
+
+// SendDecorator returns an autorest.SendDecorator recording the headers of every response into
+// snapshot, or Default if nil. It is added to a client of the Azure SDK by decorating its sender,
+// which keeps the retries of the SDK, e.g.
+//
+//	vmClient.Sender = autorest.DecorateSender(vmClient.Sender, ratelimit.SendDecorator(nil))
+func SendDecorator(snapshot *Snapshot) autorest.SendDecorator {
+	return func(s autorest.Sender) autorest.Sender {
+		return autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+			resp, err := s.Do(r)
+			if resp != nil {
+				snapshotOrDefault(snapshot).Record(resp.Header)
+			}
+			return resp, err
+		})
+	}
+}
+
+func snapshotOrDefault(snapshot *Snapshot) *Snapshot {
+	if snapshot == nil {
+		return Default
+	}
+	return snapshot
+}